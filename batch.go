@@ -0,0 +1,94 @@
+package main
+
+// batchLexer tracks whether the batch text read so far ends inside a string literal, a
+// bracketed or double-quoted identifier, or a block comment, where a batch separator line
+// is just more text.
+type batchLexer struct {
+	inString     bool
+	inBracket    bool
+	inQuoted     bool
+	commentDepth int
+}
+
+// scanLine advances the lexer state over one line of batch text.
+func (l *batchLexer) scanLine(line string) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		next := byte(0)
+		if i+1 < len(line) {
+			next = line[i+1]
+		}
+
+		switch {
+		case l.commentDepth > 0:
+			// T-SQL block comments nest:
+			if c == '/' && next == '*' {
+				l.commentDepth++
+				i++
+			} else if c == '*' && next == '/' {
+				l.commentDepth--
+				i++
+			}
+		case l.inString:
+			// '' is an escaped quote:
+			if c == '\'' {
+				if next == '\'' {
+					i++
+				} else {
+					l.inString = false
+				}
+			}
+		case l.inBracket:
+			// ]] is an escaped bracket:
+			if c == ']' {
+				if next == ']' {
+					i++
+				} else {
+					l.inBracket = false
+				}
+			}
+		case l.inQuoted:
+			// "" is an escaped quote:
+			if c == '"' {
+				if next == '"' {
+					i++
+				} else {
+					l.inQuoted = false
+				}
+			}
+		case c == '-' && next == '-':
+			// line comment runs to the end of the line:
+			return
+		case c == '/' && next == '*':
+			l.commentDepth++
+			i++
+		case c == '\'':
+			l.inString = true
+		case c == '[':
+			l.inBracket = true
+		case c == '"':
+			l.inQuoted = true
+		}
+	}
+}
+
+// open describes the unterminated construct the batch text ends inside, or returns "" if none.
+func (l *batchLexer) open() string {
+	switch {
+	case l.commentDepth > 0:
+		return "block comment"
+	case l.inString:
+		return "string literal"
+	case l.inBracket:
+		return "bracketed identifier"
+	case l.inQuoted:
+		return "quoted identifier"
+	default:
+		return ""
+	}
+}
+
+// reset clears the lexer state for the next batch.
+func (l *batchLexer) reset() {
+	*l = batchLexer{}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatchLexer(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"plain", []string{"SELECT 1"}, ""},
+		{"string", []string{"SELECT 'a"}, "string literal"},
+		{"closed string", []string{"SELECT 'a", "b'"}, ""},
+		{"escaped quote", []string{"SELECT 'it''s'"}, ""},
+		{"escaped quote left open", []string{"SELECT 'it''"}, "string literal"},
+		{"unicode string", []string{"SELECT N'a"}, "string literal"},
+		{"bracket", []string{"SELECT [a"}, "bracketed identifier"},
+		{"escaped bracket", []string{"SELECT [a]]b]"}, ""},
+		{"escaped bracket left open", []string{"SELECT [a]]"}, "bracketed identifier"},
+		{"quote in bracket", []string{"SELECT [it's]"}, ""},
+		{"double quoted", []string{`SELECT 1 AS "it's"`}, ""},
+		{"double quoted left open", []string{`SELECT 1 AS "a`}, "quoted identifier"},
+		{"block comment", []string{"/* a"}, "block comment"},
+		{"closed block comment", []string{"/* a", "b */ SELECT 1"}, ""},
+		{"nested block comment", []string{"/* a /* b */"}, "block comment"},
+		{"closed nested block comment", []string{"/* a /* b */ */"}, ""},
+		{"quote in block comment", []string{"/* don't */"}, ""},
+		{"line comment", []string{"SELECT 1 -- don't /* or ["}, ""},
+		{"line comment in string", []string{"SELECT '--", "'"}, ""},
+		{"block comment in string", []string{"SELECT '/*'"}, ""},
+	}
+	for _, test := range tests {
+		var lexer batchLexer
+		for _, line := range test.lines {
+			lexer.scanLine(line)
+		}
+		if got := lexer.open(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
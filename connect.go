@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/azuread"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"math"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// newConnector creates the driver connector for the connection string using the -auth mode,
+// optionally overriding the initial database. connectTimeout becomes the dial timeout unless
+// the connection string sets its own.
+func newConnector(connectionString string, auth string, database string, connectTimeout time.Duration) (*mssql.Connector, error) {
+	connectionString, err := connectionStringFor(connectionString, auth, database, connectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth == "sql" {
+		return mssql.NewConnector(connectionString)
+	}
+	return azuread.NewConnector(connectionString)
+}
+
+// connectionStringFor returns the connection string with the parameters newConnector sets.
+func connectionStringFor(connectionString string, auth string, database string, connectTimeout time.Duration) (string, error) {
+	config, err := msdsn.Parse(connectionString)
+	if err != nil {
+		return "", err
+	}
+
+	params := map[string]string{}
+
+	// credentials from the environment never override the connection string's own:
+	setDefault := func(name string, value string) {
+		if _, exists := config.Parameters[name]; !exists && value != "" {
+			params[name] = value
+		}
+	}
+
+	switch auth {
+	case "sql":
+	case "azuread-default":
+		// DefaultAzureCredential reads AZURE_* variables itself:
+		setDefault("fedauth", azuread.ActiveDirectoryDefault)
+	case "azuread-msi":
+		setDefault("fedauth", azuread.ActiveDirectoryManagedIdentity)
+		// a user-assigned identity is selected by its client id:
+		setDefault("user id", os.Getenv("AZURE_CLIENT_ID"))
+	case "azuread-password":
+		setDefault("fedauth", azuread.ActiveDirectoryPassword)
+		setDefault("user id", os.Getenv("AZURE_USERNAME"))
+		setDefault("password", os.Getenv("AZURE_PASSWORD"))
+		setDefault("applicationclientid", os.Getenv("AZURE_CLIENT_ID"))
+	default:
+		return "", fmt.Errorf("unknown authentication mode '%s' (via -auth flag); expected sql, azuread-default, azuread-msi, or azuread-password", auth)
+	}
+
+	if connectTimeout > 0 {
+		// the driver takes whole seconds:
+		setDefault("dial timeout", strconv.FormatInt(int64(math.Ceil(connectTimeout.Seconds())), 10))
+	}
+
+	if database != "" {
+		params["database"] = database
+	}
+
+	return withConnectionParams(connectionString, params)
+}
+
+// withConnectionParams sets params in the connection string, overriding any existing values.
+func withConnectionParams(connectionString string, params map[string]string) (string, error) {
+	if len(params) == 0 {
+		return connectionString, nil
+	}
+
+	// add in a stable order:
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if strings.HasPrefix(connectionString, "sqlserver://") {
+		u, err := url.Parse(connectionString)
+		if err != nil {
+			return "", err
+		}
+
+		q := u.Query()
+		for _, name := range names {
+			// the driver lowercases keys, so drop any differently-cased duplicate:
+			for key := range q {
+				if strings.EqualFold(key, name) {
+					q.Del(key)
+				}
+			}
+			q.Set(name, params[name])
+		}
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	}
+
+	// later keys win when an ADO-style or ODBC connection string repeats one:
+	odbc := strings.HasPrefix(connectionString, "odbc:")
+	sb := strings.Builder{}
+	sb.WriteString(strings.TrimRight(connectionString, "; "))
+	for _, name := range names {
+		if odbc {
+			// ODBC braces a value so it may contain any character:
+			sb.WriteRune(';')
+			sb.WriteString(name)
+			sb.WriteString("={")
+			sb.WriteString(strings.ReplaceAll(params[name], "}", "}}"))
+			sb.WriteRune('}')
+			continue
+		}
+		if strings.ContainsRune(params[name], ';') {
+			return "", fmt.Errorf("connection parameter '%s' contains ';'; use a sqlserver:// URL connection string instead", name)
+		}
+		sb.WriteRune(';')
+		sb.WriteString(name)
+		sb.WriteRune('=')
+		sb.WriteString(params[name])
+	}
+	return sb.String(), nil
+}
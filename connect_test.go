@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionStringFor(t *testing.T) {
+	tests := []struct {
+		name             string
+		connectionString string
+		database         string
+		connectTimeout   time.Duration
+		wantDatabase     string
+		wantDialTimeout  time.Duration
+	}{
+		{"ado database", "server=db;Initial Catalog=foo", "bar", 0, "bar", 15 * time.Second},
+		{"ado database unchanged", "server=db;Initial Catalog=foo", "", 0, "foo", 15 * time.Second},
+		{"ado dial timeout", "server=db;Dial Timeout=30;", "", 10 * time.Second, "", 30 * time.Second},
+		{"ado connect timeout", "server=db;database=foo", "bar", 1500 * time.Millisecond, "bar", 2 * time.Second},
+		{"url database", "sqlserver://db?database=foo", "bar", 0, "bar", 15 * time.Second},
+		{"url database case", "sqlserver://db?Database=foo&app+name=x", "bar", 0, "bar", 15 * time.Second},
+		{"url dial timeout", "sqlserver://user:p%40ss@db?dial+timeout=30", "bar", 10 * time.Second, "bar", 30 * time.Second},
+		{"url connect timeout", "sqlserver://db", "", 10 * time.Second, "", 10 * time.Second},
+		{"odbc database", "odbc:server=db;Database=foo", "bar", 0, "bar", 15 * time.Second},
+		{"odbc braced database", "odbc:server=db;database=foo;", "b;a}r", 0, "b;a}r", 15 * time.Second},
+		{"odbc dial timeout", "odbc:server=db;dial timeout=30", "bar", 10 * time.Second, "bar", 30 * time.Second},
+	}
+	for _, test := range tests {
+		connectionString, err := connectionStringFor(test.connectionString, "sql", test.database, test.connectTimeout)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		config, err := msdsn.Parse(connectionString)
+		if err != nil {
+			t.Errorf("%s: parsing %q: %v", test.name, connectionString, err)
+			continue
+		}
+		if strings.HasPrefix(connectionString, "sqlserver://") && test.database != "" && strings.Contains(connectionString, "=foo") {
+			// the driver would pick either of two differently-cased keys at random:
+			t.Errorf("%s: got %q, still naming the replaced database", test.name, connectionString)
+		}
+		if config.Database != test.wantDatabase {
+			t.Errorf("%s: got database %q from %q, want %q", test.name, config.Database, connectionString, test.wantDatabase)
+		}
+		if config.DialTimeout != test.wantDialTimeout {
+			t.Errorf("%s: got dial timeout %s from %q, want %s", test.name, config.DialTimeout, connectionString, test.wantDialTimeout)
+		}
+	}
+}
+
+func TestConnectionStringForCredentials(t *testing.T) {
+	t.Setenv("AZURE_USERNAME", "env-user")
+	t.Setenv("AZURE_PASSWORD", "env-password")
+	t.Setenv("AZURE_CLIENT_ID", "env-client")
+
+	tests := []struct {
+		connectionString string
+		wantUser         string
+		wantPassword     string
+	}{
+		{"server=db", "env-user", "env-password"},
+		{"server=db;uid=cs-user", "cs-user", "env-password"},
+		{"sqlserver://cs-user:cs-password@db", "cs-user", "cs-password"},
+		{"odbc:server=db;user id=cs-user", "cs-user", "env-password"},
+	}
+	for _, test := range tests {
+		connectionString, err := connectionStringFor(test.connectionString, "azuread-password", "", 0)
+		if err != nil {
+			t.Errorf("%q: %v", test.connectionString, err)
+			continue
+		}
+		config, err := msdsn.Parse(connectionString)
+		if err != nil {
+			t.Errorf("%q: parsing %q: %v", test.connectionString, connectionString, err)
+			continue
+		}
+		if config.User != test.wantUser || config.Password != test.wantPassword {
+			t.Errorf("%q: got user %q and password %q from %q, want %q and %q", test.connectionString, config.User, config.Password, connectionString, test.wantUser, test.wantPassword)
+		}
+		if got := config.Parameters["fedauth"]; got != "ActiveDirectoryPassword" {
+			t.Errorf("%q: got fedauth %q, want ActiveDirectoryPassword", test.connectionString, got)
+		}
+	}
+}
+
+func TestConnectionStringForErrors(t *testing.T) {
+	if _, err := connectionStringFor("server=db", "sql", "b;ar", 0); err == nil {
+		t.Error("ADO connection string accepted a database containing ';'")
+	}
+	if _, err := connectionStringFor("server=db", "bogus", "", 0); err == nil {
+		t.Error("accepted an unknown -auth mode")
+	}
+}
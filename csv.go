@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// headerMode selects how the column header line of each result set is written.
+type headerMode int
+
+const (
+	// headerFull writes "[name] TYPE(length) NULL" for each column.
+	headerFull headerMode = iota
+	// headerNames writes just the column names.
+	headerNames
+	// headerNone omits the header line.
+	headerNone
+)
+
+// parseHeaderMode parses a -headermode flag value.
+func parseHeaderMode(s string) (mode headerMode, ok bool) {
+	switch strings.ToLower(s) {
+	case "full":
+		return headerFull, true
+	case "names":
+		return headerNames, true
+	case "none":
+		return headerNone, true
+	default:
+		return
+	}
+}
+
+type csvOptions struct {
+	nullString string
+	// quoteNull quotes empty strings and literal null-string text so that only
+	// real NULLs are written as the bare null string.
+	quoteNull   bool
+	comma       rune
+	useCRLF     bool
+	header      headerMode
+	valueFormat valueFormatter
+}
+
+type csvResultWriter struct {
+	bw   *bufio.Writer
+	cw   *csv.Writer
+	opts csvOptions
+
+	colTypes  []columnType
+	formatted []string
+	nulls     []bool
+}
+
+func newCSVResultWriter(out io.Writer, opts csvOptions) *csvResultWriter {
+	// csv.Writer buffers internally; bw lets us interleave our own lines in order:
+	bw := bufio.NewWriter(out)
+	cw := csv.NewWriter(bw)
+	cw.Comma = opts.comma
+	cw.UseCRLF = opts.useCRLF
+
+	return &csvResultWriter{
+		bw:   bw,
+		cw:   cw,
+		opts: opts,
+	}
+}
+
+func (w *csvResultWriter) WriteComment(text string) (err error) {
+	// flush csv.Writer's buffer into bw so lines stay in order:
+	w.cw.Flush()
+	if err = w.cw.Error(); err != nil {
+		return
+	}
+
+	_, err = w.bw.WriteString("-- " + text + w.lineEnding())
+	return
+}
+
+func (w *csvResultWriter) BeginResultSet(colTypes []columnType) error {
+	w.colTypes = colTypes
+	w.formatted = make([]string, len(colTypes))
+	w.nulls = make([]bool, len(colTypes))
+
+	if w.opts.header == headerNone {
+		return nil
+	}
+
+	// write the CSV header:
+	return w.cw.Write(w.writeHeader(colTypes))
+}
+
+func (w *csvResultWriter) WriteRow(values []any) (err error) {
+	// format column values for output to CSV:
+	for i, value := range values {
+		w.nulls[i] = value == nil
+		if value == nil {
+			w.formatted[i] = w.opts.nullString
+			continue
+		}
+
+		if w.formatted[i], err = w.opts.valueFormat.format(w.colTypes[i], value); err != nil {
+			return fmt.Errorf("error formatting column %d: %w", i+1, err)
+		}
+	}
+
+	if w.opts.quoteNull {
+		return w.writeQuotedRecord()
+	}
+
+	// write the CSV line:
+	return w.cw.Write(w.formatted)
+}
+
+// writeQuotedRecord writes the formatted row like csv.Writer does, except that non-NULL
+// values which are empty or equal to the null string are always quoted.
+func (w *csvResultWriter) writeQuotedRecord() (err error) {
+	// flush csv.Writer's buffer into bw so lines stay in order:
+	w.cw.Flush()
+	if err = w.cw.Error(); err != nil {
+		return
+	}
+
+	for i, field := range w.formatted {
+		if i > 0 {
+			_, _ = w.bw.WriteRune(w.opts.comma)
+		}
+
+		if w.nulls[i] || !(field == "" || field == w.opts.nullString || w.fieldNeedsQuotes(field)) {
+			_, _ = w.bw.WriteString(field)
+			continue
+		}
+
+		_ = w.bw.WriteByte('"')
+		_, _ = w.quoteEscaper().WriteString(w.bw, field)
+		_ = w.bw.WriteByte('"')
+	}
+
+	_, err = w.bw.WriteString(w.lineEnding())
+	return
+}
+
+// quoteEscaper escapes quoted field contents the way csv.Writer does.
+func (w *csvResultWriter) quoteEscaper() *strings.Replacer {
+	if w.opts.useCRLF {
+		return crlfQuoteEscaper
+	}
+	return lfQuoteEscaper
+}
+
+var (
+	lfQuoteEscaper   = strings.NewReplacer(`"`, `""`)
+	crlfQuoteEscaper = strings.NewReplacer(`"`, `""`, "\r", "", "\n", "\r\n")
+)
+
+func (w *csvResultWriter) lineEnding() string {
+	if w.opts.useCRLF {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// fieldNeedsQuotes mirrors the quoting rules of csv.Writer.
+func (w *csvResultWriter) fieldNeedsQuotes(field string) bool {
+	if field == "" {
+		return false
+	}
+	if field == `\.` || strings.ContainsRune(field, w.opts.comma) || strings.ContainsAny(field, "\"\r\n") {
+		return true
+	}
+
+	r, _ := utf8.DecodeRuneInString(field)
+	return unicode.IsSpace(r)
+}
+
+func (w *csvResultWriter) EndResultSet() error {
+	return nil
+}
+
+func (w *csvResultWriter) SeparateResultSets() error {
+	return w.cw.Write(nil)
+}
+
+func (w *csvResultWriter) EndBatch() (err error) {
+	if err = w.Flush(); err != nil {
+		return
+	}
+
+	_, err = w.bw.WriteString("---" + w.lineEnding())
+	if err != nil {
+		return
+	}
+	return w.bw.Flush()
+}
+
+func (w *csvResultWriter) Flush() (err error) {
+	w.cw.Flush()
+	if err = w.cw.Error(); err != nil {
+		return
+	}
+	return w.bw.Flush()
+}
+
+func (w *csvResultWriter) writeHeader(colTypes []columnType) (colNames []string) {
+	colNames = make([]string, len(colTypes))
+
+	// output column header including types:
+	for i := range colTypes {
+		nullable, hasNullable := colTypes[i].Nullable()
+		length, hasLength := colTypes[i].Length()
+		decimalSize, decimalScale, hasDecimalSize := colTypes[i].DecimalSize()
+
+		colName := colTypes[i].Name()
+		if w.opts.header == headerNames {
+			// just the bare column name without decoration:
+			colNames[i] = colName
+			continue
+		}
+
+		sb := strings.Builder{}
+		/*if colName != ""*/ {
+			sb.WriteRune('[')
+			sb.WriteString(strings.ReplaceAll(colName, "]", "]]"))
+			sb.WriteRune(']')
+			sb.WriteRune(' ')
+		}
+		sb.WriteString(colTypes[i].DatabaseTypeName())
+		if hasLength {
+			sb.WriteRune('(')
+			if length == 2147483645 || length == 1073741822 {
+				sb.WriteString("max")
+			} else {
+				sb.WriteString(strconv.FormatInt(length, 10))
+			}
+			sb.WriteRune(')')
+		} else if hasDecimalSize {
+			sb.WriteRune('(')
+			sb.WriteString(strconv.FormatInt(decimalSize, 10))
+			sb.WriteRune(',')
+			sb.WriteString(strconv.FormatInt(decimalScale, 10))
+			sb.WriteRune(')')
+		}
+		if hasNullable {
+			sb.WriteRune(' ')
+			if !nullable {
+				sb.WriteString("NOT ")
+			}
+			sb.WriteString("NULL")
+		}
+		colNames[i] = sb.String()
+	}
+
+	return
+}
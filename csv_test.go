@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+)
+
+func TestWriteHeader(t *testing.T) {
+	colTypes := []columnType{
+		testColumn{name: "a]b", typeName: "INT"},
+		testColumn{name: "[name]", typeName: "NVARCHAR", length: 50, hasLength: true, nullable: true},
+		testColumn{name: "notes", typeName: "NVARCHAR", length: 1073741822, hasLength: true, nullable: true},
+		testColumn{name: "amount", typeName: "DECIMAL", precision: 18, scale: 4, hasDecimal: true},
+	}
+
+	tests := []struct {
+		header headerMode
+		want   []string
+	}{
+		{headerFull, []string{"[a]]b] INT NOT NULL", "[[name]]] NVARCHAR(50) NULL", "[notes] NVARCHAR(max) NULL", "[amount] DECIMAL(18,4) NOT NULL"}},
+		{headerNames, []string{"a]b", "[name]", "notes", "amount"}},
+	}
+	for _, test := range tests {
+		w := &csvResultWriter{opts: csvOptions{header: test.header}}
+		if got := w.writeHeader(colTypes); !slices.Equal(got, test.want) {
+			t.Errorf("header mode %d: got %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestHeaderModes(t *testing.T) {
+	colTypes := []columnType{
+		testColumn{name: "a]b", typeName: "INT"},
+		testColumn{name: "s", typeName: "VARCHAR", length: 10, hasLength: true, nullable: true},
+	}
+	rows := [][]any{{int64(1), "x"}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"full", "[a]]b] INT NOT NULL,[s] VARCHAR(10) NULL\n1,x\n"},
+		{"names", "a]b,s\n1,x\n"},
+		{"none", "1,x\n"},
+	}
+	for _, test := range tests {
+		header, ok := parseHeaderMode(test.name)
+		if !ok {
+			t.Fatalf("parseHeaderMode(%q) failed", test.name)
+		}
+		got := writeResultSets(t, newTestCSVWriter(csvOptions{nullString: "NULL", header: header}), colTypes, rows)
+		if got != test.want {
+			t.Errorf("-headermode %s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, ok := parseHeaderMode("bogus"); ok {
+		t.Error("parseHeaderMode accepted an unknown mode")
+	}
+}
+
+func TestQuoteNull(t *testing.T) {
+	colTypes := []columnType{
+		testColumn{name: "s", typeName: "NVARCHAR", length: 50, hasLength: true, nullable: true},
+		testColumn{name: "n", typeName: "INT"},
+	}
+
+	tests := []struct {
+		value any
+		// quoted is the -quotenull field when it differs from csv.Writer's:
+		quoted string
+	}{
+		{value: "", quoted: `""`},
+		{value: "NULL", quoted: `"NULL"`},
+		{value: nil},
+		{value: "plain"},
+		{value: " leading space"},
+		{value: "\tleading tab"},
+		{value: "trailing space "},
+		{value: `say "hi"`},
+		{value: "a,b"},
+		{value: "line1\nline2"},
+		{value: "line1\r\nline2"},
+		{value: "bare\rreturn"},
+		{value: `\.`},
+		{value: "NULL "},
+	}
+	for _, useCRLF := range []bool{false, true} {
+		for _, test := range tests {
+			rows := [][]any{{test.value, int64(1)}}
+			got := writeResultSets(t, newTestCSVWriter(csvOptions{nullString: "NULL", quoteNull: true, useCRLF: useCRLF, header: headerNone}), colTypes, rows)
+
+			var wantLine string
+			if test.quoted != "" {
+				wantLine = test.quoted + ",1\n"
+				if useCRLF {
+					wantLine = test.quoted + ",1\r\n"
+				}
+			} else {
+				// csv.Writer's output for the same formatted fields:
+				field, _ := test.value.(string)
+				if test.value == nil {
+					field = "NULL"
+				}
+				var want bytes.Buffer
+				cw := csv.NewWriter(&want)
+				cw.UseCRLF = useCRLF
+				if err := cw.Write([]string{field, "1"}); err != nil {
+					t.Fatal(err)
+				}
+				cw.Flush()
+				wantLine = want.String()
+			}
+
+			if got != wantLine {
+				t.Errorf("crlf %v, value %q: got %q, want %q", useCRLF, test.value, got, wantLine)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// expandedResultWriter writes each row as a block of "ColumnName: value" lines, like psql's \x mode.
+type expandedResultWriter struct {
+	bw          *bufio.Writer
+	nullString  string
+	valueFormat valueFormatter
+
+	colTypes []columnType
+	labels   []string
+	indent   string
+	rowCount int
+}
+
+func newExpandedResultWriter(out io.Writer, nullString string, valueFormat valueFormatter) *expandedResultWriter {
+	return &expandedResultWriter{
+		bw:          bufio.NewWriter(out),
+		nullString:  nullString,
+		valueFormat: valueFormat,
+	}
+}
+
+func (w *expandedResultWriter) WriteComment(text string) (err error) {
+	_, err = w.bw.WriteString("-- " + text + "\n")
+	return
+}
+
+func (w *expandedResultWriter) BeginResultSet(colTypes []columnType) error {
+	w.colTypes = colTypes
+	w.rowCount = 0
+
+	// pad column names so values line up:
+	width := 0
+	for i := range colTypes {
+		if n := len([]rune(colTypes[i].Name())); n > width {
+			width = n
+		}
+	}
+	w.labels = make([]string, len(colTypes))
+	for i := range colTypes {
+		name := colTypes[i].Name()
+		w.labels[i] = name + strings.Repeat(" ", width-len([]rune(name))) + ": "
+	}
+	w.indent = strings.Repeat(" ", width+2)
+
+	return nil
+}
+
+func (w *expandedResultWriter) WriteRow(values []any) (err error) {
+	if w.rowCount > 0 {
+		_, _ = w.bw.WriteString("----\n")
+	}
+	w.rowCount++
+
+	for i, value := range values {
+		formatted := w.nullString
+		if value != nil {
+			if formatted, err = w.valueFormat.format(w.colTypes[i], value); err != nil {
+				return fmt.Errorf("error formatting column %d: %w", i+1, err)
+			}
+		}
+
+		// indent continuation lines past the labels so they cannot pass for another column:
+		_, _ = w.bw.WriteString(w.labels[i])
+		_, _ = w.bw.WriteString(strings.ReplaceAll(formatted, "\n", "\n"+w.indent))
+		if err = w.bw.WriteByte('\n'); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (w *expandedResultWriter) EndResultSet() error {
+	return nil
+}
+
+func (w *expandedResultWriter) SeparateResultSets() error {
+	return w.bw.WriteByte('\n')
+}
+
+func (w *expandedResultWriter) EndBatch() (err error) {
+	if _, err = w.bw.WriteString("---\n"); err != nil {
+		return
+	}
+	return w.Flush()
+}
+
+func (w *expandedResultWriter) Flush() error {
+	return w.bw.Flush()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExpandedMultilineValues(t *testing.T) {
+	colTypes := []columnType{
+		testColumn{name: "id", typeName: "INT"},
+		testColumn{name: "notes", typeName: "NVARCHAR", length: 100, hasLength: true, nullable: true},
+	}
+	rows := [][]any{
+		{int64(1), "first line\nid: 2\n----\nlast line"},
+		{int64(2), nil},
+	}
+	got := writeResultSets(t, func(out *bytes.Buffer) resultWriter {
+		return newExpandedResultWriter(out, "NULL", valueFormatter{})
+	}, colTypes, rows)
+
+	want := "id   : 1\n" +
+		"notes: first line\n" +
+		"       id: 2\n" +
+		"       ----\n" +
+		"       last line\n" +
+		"----\n" +
+		"id   : 2\n" +
+		"notes: NULL\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	mssql "github.com/microsoft/go-mssqldb"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// valueFormatter formats non-NULL column values as text.
+type valueFormatter struct {
+	// timeFormat overrides the default ISO 8601 layouts for date/time values.
+	timeFormat string
+	// binaryBase64 encodes binary values as standard base64 instead of 0x-prefixed hex.
+	binaryBase64 bool
+	// trimStrings strips trailing spaces from character column values, e.g. CHAR(n) padding.
+	trimStrings bool
+	// bitTrueFalse writes BIT values as true/false instead of 1/0.
+	bitTrueFalse bool
+}
+
+// format formats a non-NULL column value as text.
+func (f valueFormatter) format(colType columnType, value any) (formatted string, err error) {
+	// specialize formatting based on type:
+	switch colType.DatabaseTypeName() {
+	case "UNIQUEIDENTIFIER":
+		formatted, err = uniqueIdentifierText(value)
+	case "DECIMAL", "MONEY", "SMALLMONEY":
+		formatted, err = formatDecimal(colType, value)
+	case "BIT":
+		b, ok := bitValue(value)
+		switch {
+		case !ok:
+			formatted = fmt.Sprintf("%v", value)
+		case f.bitTrueFalse:
+			formatted = strconv.FormatBool(b)
+		case b:
+			formatted = "1"
+		default:
+			formatted = "0"
+		}
+	default:
+		switch v := value.(type) {
+		case []byte:
+			formatted = f.formatBinary(v)
+		case time.Time:
+			formatted = f.formatTime(colType, v)
+		case string:
+			formatted = f.formatString(colType, v)
+		default:
+			formatted = fmt.Sprintf("%v", v)
+		}
+	}
+
+	return
+}
+
+// uniqueIdentifierText formats a UNIQUEIDENTIFIER value. The driver returns SQL Server's
+// mixed-endian 16 bytes, which mssql.UniqueIdentifier reorders; any other type is formatted
+// as-is. Bytes of the wrong length cannot be a GUID and are an error.
+func uniqueIdentifierText(value any) (string, error) {
+	b, ok := value.([]byte)
+	if !ok {
+		return fmt.Sprintf("%v", value), nil
+	}
+
+	var uv mssql.UniqueIdentifier
+	if err := uv.Scan(b); err != nil {
+		return "", fmt.Errorf("error constructing uuid from %d bytes: %w", len(b), err)
+	}
+	return uv.String(), nil
+}
+
+// decimalText returns the exact text of a DECIMAL, MONEY, or SMALLMONEY value.
+func decimalText(value any) string {
+	// driver returns exact decimal text as []byte, but tolerate anything else:
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// formatDecimal formats a DECIMAL, MONEY, or SMALLMONEY value exactly, padded or rounded to the
+// column's scale, always with '.' as the decimal separator. Text that is not a number cannot be
+// written faithfully at any scale and is an error.
+func formatDecimal(colType columnType, value any) (string, error) {
+	text := decimalText(value)
+
+	var r big.Rat
+	if _, ok := r.SetString(text); !ok {
+		return "", fmt.Errorf("invalid %s value '%s'", colType.DatabaseTypeName(), text)
+	}
+
+	_, scale, hasScale := colType.DecimalSize()
+	if !hasScale {
+		return text, nil
+	}
+	return r.FloatString(int(scale)), nil
+}
+
+// bitValue converts a BIT column value to a bool, reporting false if it is not recognizably boolean.
+func bitValue(value any) (b bool, ok bool) {
+	var err error
+	switch v := value.(type) {
+	case bool:
+		return v, true
+	case int64:
+		return v != 0, true
+	case []byte:
+		b, err = strconv.ParseBool(string(v))
+	case string:
+		b, err = strconv.ParseBool(v)
+	default:
+		return false, false
+	}
+	return b, err == nil
+}
+
+// formatString strips trailing spaces from character column values, if requested.
+func (f valueFormatter) formatString(colType columnType, s string) string {
+	if !f.trimStrings {
+		return s
+	}
+
+	switch colType.DatabaseTypeName() {
+	case "CHAR", "NCHAR", "VARCHAR", "NVARCHAR":
+		return strings.TrimRight(s, " ")
+	default:
+		return s
+	}
+}
+
+// formatBinary formats a binary value as 0x-prefixed hex or, if requested, base64.
+func (f valueFormatter) formatBinary(b []byte) string {
+	if f.binaryBase64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+
+	return "0x" + hex.EncodeToString(b)
+}
+
+// formatTime formats a date/time value as ISO 8601 at the column's own precision, unless overridden.
+func (f valueFormatter) formatTime(colType columnType, t time.Time) string {
+	if f.timeFormat != "" {
+		return t.Format(f.timeFormat)
+	}
+
+	return t.Format(isoTimeLayout(colType))
+}
+
+// isoTimeLayout returns the ISO 8601 layout matching a date/time column's type and fractional-second scale.
+func isoTimeLayout(colType columnType) string {
+	fraction := func(scale int64) string {
+		if scale <= 0 {
+			return ""
+		}
+		return "." + strings.Repeat("0", int(scale))
+	}
+
+	_, scale, hasScale := colType.DecimalSize()
+	if !hasScale {
+		scale = 7
+	}
+
+	switch colType.DatabaseTypeName() {
+	case "DATE":
+		return "2006-01-02"
+	case "TIME":
+		return "15:04:05" + fraction(scale)
+	case "SMALLDATETIME":
+		return "2006-01-02T15:04:05"
+	case "DATETIME":
+		return "2006-01-02T15:04:05" + fraction(3)
+	case "DATETIME2":
+		return "2006-01-02T15:04:05" + fraction(scale)
+	case "DATETIMEOFFSET":
+		return "2006-01-02T15:04:05" + fraction(scale) + "-07:00"
+	default:
+		return time.RFC3339Nano
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+func TestDecimalCSV(t *testing.T) {
+	colTypes := []columnType{testColumn{name: "amount", typeName: "DECIMAL", precision: 18, scale: 4, hasDecimal: true, nullable: true}}
+	rows := [][]any{
+		{[]byte("10.5000")},
+		{[]byte("-1234.5600")},
+		{[]byte("-0.0001")},
+		{[]byte("0.0000")},
+		{nil},
+	}
+	got := writeResultSets(t, newTestCSVWriter(csvOptions{nullString: "NULL"}), colTypes, rows)
+
+	want := "\"[amount] DECIMAL(18,4) NULL\"\n10.5000\n-1234.5600\n-0.0001\n0.0000\nNULL\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestUnexpectedDriverTypes(t *testing.T) {
+	connector := &fakeConnector{resultSets: []fakeResultSet{{
+		columns: []fakeColumn{
+			{name: "flag", typeName: "BIT"},
+			{name: "id", typeName: "UNIQUEIDENTIFIER"},
+			{name: "amount", typeName: "DECIMAL", precision: 10, scale: 2},
+			{name: "price", typeName: "MONEY"},
+		},
+		rows: [][]driver.Value{
+			{int64(1), "6F9619FF-8B86-D011-B42D-00C04FC964FF", 1.5, "12.3400"},
+			{"false", int64(42), "-7", []byte("0.5000")},
+			{1.5, nil, int64(3), nil},
+		},
+	}}}
+	out, stderr := execFakeScript(t, connector, "SELECT 1\nGO\n")
+
+	want := "[flag] BIT,[id] UNIQUEIDENTIFIER,\"[amount] DECIMAL(10,2)\",[price] MONEY\n" +
+		"1,6F9619FF-8B86-D011-B42D-00C04FC964FF,1.50,12.3400\n" +
+		"0,42,-7.00,0.5000\n" +
+		"1.5,NULL,3.00,NULL\n" +
+		"---\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if stderr != "" {
+		t.Errorf("unexpected stderr %q", stderr)
+	}
+}
+
+func TestUnformattableDriverValue(t *testing.T) {
+	connector := &fakeConnector{resultSets: []fakeResultSet{{
+		columns: []fakeColumn{
+			{name: "n", typeName: "INT"},
+			{name: "id", typeName: "UNIQUEIDENTIFIER"},
+		},
+		rows: [][]driver.Value{
+			{int64(1), nil},
+			{int64(2), []byte{1, 2, 3}},
+		},
+	}}}
+	_, stderr := execFakeScript(t, connector, "SELECT 1\nGO\n")
+
+	want := "error in row 2 writing: error formatting column 2: error constructing uuid from 3 bytes: "
+	if !strings.HasPrefix(stderr, want) {
+		t.Errorf("got stderr %q, want prefix %q", stderr, want)
+	}
+}
+
+func TestFormatDecimal(t *testing.T) {
+	decimal := func(precision int64, scale int64) columnType {
+		return testColumn{name: "d", typeName: "DECIMAL", precision: precision, scale: scale, hasDecimal: true}
+	}
+	money := testColumn{name: "m", typeName: "MONEY"}
+
+	tests := []struct {
+		colType columnType
+		value   any
+		want    string
+	}{
+		{decimal(18, 4), []byte("10.5"), "10.5000"},
+		{decimal(18, 4), []byte("-0.5000"), "-0.5000"},
+		{decimal(18, 4), []byte("-0.00001"), "-0.0000"},
+		{decimal(18, 0), []byte("42"), "42"},
+		{decimal(18, 0), []byte("-42"), "-42"},
+		{decimal(18, 0), []byte("0"), "0"},
+		{decimal(38, 0), []byte("99999999999999999999999999999999999999"), "99999999999999999999999999999999999999"},
+		{decimal(38, 10), []byte("-9999999999999999999999999999.9999999999"), "-9999999999999999999999999999.9999999999"},
+		{decimal(38, 38), []byte("0.00000000000000000000000000000000000001"), "0.00000000000000000000000000000000000001"},
+		{decimal(10, 2), 1.5, "1.50"},
+		{decimal(10, 2), "1e3", "1000.00"},
+		// without a reported scale the driver's text passes through unchanged:
+		{money, []byte("-12.3400"), "-12.3400"},
+		{money, []byte("0.5"), "0.5"},
+	}
+	for _, test := range tests {
+		got, err := formatDecimal(test.colType, test.value)
+		if err != nil {
+			t.Errorf("formatDecimal(%v): %v", test.value, err)
+		} else if got != test.want {
+			t.Errorf("formatDecimal(%v) = %q, want %q", test.value, got, test.want)
+		}
+	}
+
+	if _, err := formatDecimal(decimal(10, 2), []byte("1,5")); err == nil {
+		t.Error("formatDecimal accepted a comma decimal separator")
+	}
+}
+
+func TestBitFormat(t *testing.T) {
+	colTypes := []columnType{
+		testColumn{name: "a", typeName: "BIT"},
+		testColumn{name: "b", typeName: "BIT", nullable: true},
+	}
+	rows := [][]any{{true, false}, {false, nil}}
+
+	tests := []struct {
+		bitTrueFalse bool
+		want         string
+	}{
+		{false, "[a] BIT NOT NULL,[b] BIT NULL\n1,0\n0,NULL\n"},
+		{true, "[a] BIT NOT NULL,[b] BIT NULL\ntrue,false\nfalse,NULL\n"},
+	}
+	for _, test := range tests {
+		opts := csvOptions{nullString: "NULL", valueFormat: valueFormatter{bitTrueFalse: test.bitTrueFalse}}
+		if got := writeResultSets(t, newTestCSVWriter(opts), colTypes, rows); got != test.want {
+			t.Errorf("bitTrueFalse=%t: got %q, want %q", test.bitTrueFalse, got, test.want)
+		}
+	}
+}
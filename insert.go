@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// insertResultWriter writes each row as a T-SQL INSERT statement into a target table.
+type insertResultWriter struct {
+	bw    *bufio.Writer
+	table string
+
+	colTypes []columnType
+	prefix   string
+}
+
+func newInsertResultWriter(out io.Writer, table string) *insertResultWriter {
+	return &insertResultWriter{
+		bw:    bufio.NewWriter(out),
+		table: quoteTableName(table),
+	}
+}
+
+// quoteTableName bracket-quotes each part of a possibly schema-qualified table name,
+// leaving names the user has already bracketed alone.
+func quoteTableName(table string) string {
+	if strings.Contains(table, "[") {
+		return table
+	}
+
+	parts := strings.Split(table, ".")
+	for i := range parts {
+		parts[i] = quoteIdentifier(parts[i])
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteIdentifier bracket-quotes a T-SQL identifier.
+func quoteIdentifier(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+func (w *insertResultWriter) WriteComment(text string) (err error) {
+	_, err = w.bw.WriteString("-- " + text + "\n")
+	return
+}
+
+func (w *insertResultWriter) BeginResultSet(colTypes []columnType) error {
+	w.colTypes = colTypes
+
+	// every row shares the same INSERT INTO ... VALUES prefix:
+	sb := strings.Builder{}
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(w.table)
+	sb.WriteString(" (")
+	for i := range colTypes {
+		if colTypes[i].Name() == "" {
+			return fmt.Errorf("column %d has no name; -format insert needs every column named (use AS)", i+1)
+		}
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(quoteIdentifier(colTypes[i].Name()))
+	}
+	sb.WriteString(") VALUES (")
+	w.prefix = sb.String()
+
+	return nil
+}
+
+func (w *insertResultWriter) WriteRow(values []any) (err error) {
+	_, _ = w.bw.WriteString(w.prefix)
+	for i, value := range values {
+		if i > 0 {
+			_, _ = w.bw.WriteString(", ")
+		}
+
+		var literal string
+		if literal, err = sqlLiteral(w.colTypes[i], value); err != nil {
+			return fmt.Errorf("error formatting column %d: %w", i+1, err)
+		}
+		_, _ = w.bw.WriteString(literal)
+	}
+	_, err = w.bw.WriteString(");\n")
+	return
+}
+
+// sqlLiteral formats a column value as a T-SQL literal.
+func sqlLiteral(colType columnType, value any) (literal string, err error) {
+	if value == nil {
+		return "NULL", nil
+	}
+
+	// specialize formatting based on type:
+	switch colType.DatabaseTypeName() {
+	case "UNIQUEIDENTIFIER":
+		var text string
+		if text, err = uniqueIdentifierText(value); err != nil {
+			return
+		}
+		literal = quoteString(text)
+	case "DECIMAL", "MONEY", "SMALLMONEY":
+		literal, err = formatDecimal(colType, value)
+	case "BIT":
+		b, ok := bitValue(value)
+		switch {
+		case !ok:
+			literal = quoteNString(fmt.Sprintf("%v", value))
+		case b:
+			literal = "1"
+		default:
+			literal = "0"
+		}
+	default:
+		switch v := value.(type) {
+		case []byte:
+			literal = "0x" + hex.EncodeToString(v)
+		case int64:
+			literal = strconv.FormatInt(v, 10)
+		case float64:
+			literal = strconv.FormatFloat(v, 'g', -1, 64)
+		case float32:
+			// REAL NOT NULL values arrive as float32:
+			literal = strconv.FormatFloat(float64(v), 'g', -1, 32)
+		case time.Time:
+			// ISO 8601 is parsed unambiguously regardless of session DATEFORMAT:
+			literal = "'" + v.Format(isoTimeLayout(colType)) + "'"
+		case string:
+			literal = quoteNString(v)
+		default:
+			literal = quoteNString(fmt.Sprintf("%v", v))
+		}
+	}
+
+	return
+}
+
+// quoteString formats s as a T-SQL string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteNString formats s as a T-SQL Unicode string literal.
+func quoteNString(s string) string {
+	return "N" + quoteString(s)
+}
+
+func (w *insertResultWriter) EndResultSet() error {
+	return nil
+}
+
+func (w *insertResultWriter) SeparateResultSets() error {
+	return w.bw.WriteByte('\n')
+}
+
+func (w *insertResultWriter) EndBatch() (err error) {
+	// terminate each batch so the output is itself a runnable script:
+	if _, err = w.bw.WriteString("GO\n"); err != nil {
+		return
+	}
+	return w.Flush()
+}
+
+func (w *insertResultWriter) Flush() error {
+	return w.bw.Flush()
+}
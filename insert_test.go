@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestSQLLiteral(t *testing.T) {
+	tests := []struct {
+		colType testColumn
+		value   any
+		want    string
+	}{
+		{testColumn{typeName: "REAL"}, float32(0.1), "0.1"},
+		{testColumn{typeName: "REAL"}, float32(-3.25), "-3.25"},
+		{testColumn{typeName: "REAL"}, float32(math.MaxFloat32), "3.4028235e+38"},
+		{testColumn{typeName: "FLOAT"}, float64(0.1), "0.1"},
+		{testColumn{typeName: "INT"}, int64(-42), "-42"},
+		{testColumn{typeName: "NVARCHAR"}, "it's", "N'it''s'"},
+		{testColumn{typeName: "VARBINARY"}, []byte{0xde, 0xad}, "0xdead"},
+	}
+	for _, test := range tests {
+		got, err := sqlLiteral(test.colType, test.value)
+		if err != nil {
+			t.Errorf("%s %v: %v", test.colType.typeName, test.value, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s %v: got %q, want %q", test.colType.typeName, test.value, got, test.want)
+		}
+	}
+}
+
+func TestInsertColumnNames(t *testing.T) {
+	tests := []struct {
+		colTypes []columnType
+		want     string
+	}{
+		{[]columnType{testColumn{name: "id", typeName: "INT"}, testColumn{name: "a]b", typeName: "INT"}}, ""},
+		{[]columnType{testColumn{name: "", typeName: "INT"}}, "column 1 has no name; -format insert needs every column named (use AS)"},
+		{[]columnType{testColumn{name: "id", typeName: "INT"}, testColumn{name: "", typeName: "INT"}}, "column 2 has no name; -format insert needs every column named (use AS)"},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		err := newInsertResultWriter(&out, "dbo.Widget").BeginResultSet(test.colTypes)
+		if test.want == "" {
+			if err != nil {
+				t.Errorf("got %v, want no error", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.want {
+			t.Errorf("got %v, want %q", err, test.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// jsonResultWriter writes each result set as a JSON array of objects keyed by column name,
+// or in NDJSON mode as one JSON object per line.
+type jsonResultWriter struct {
+	bw          *bufio.Writer
+	ndjson      bool
+	valueFormat valueFormatter
+
+	colTypes []columnType
+	colKeys  [][]byte
+	rowCount int
+}
+
+func newJSONResultWriter(out io.Writer, valueFormat valueFormatter) *jsonResultWriter {
+	return &jsonResultWriter{
+		bw:          bufio.NewWriter(out),
+		valueFormat: valueFormat,
+	}
+}
+
+func newNDJSONResultWriter(out io.Writer, valueFormat valueFormatter) *jsonResultWriter {
+	return &jsonResultWriter{
+		bw:          bufio.NewWriter(out),
+		ndjson:      true,
+		valueFormat: valueFormat,
+	}
+}
+
+func (w *jsonResultWriter) WriteComment(text string) error {
+	// JSON has no comments; use -servercol to tag rows instead.
+	return nil
+}
+
+func (w *jsonResultWriter) BeginResultSet(colTypes []columnType) (err error) {
+	// pre-encode column names as object keys:
+	w.colTypes = colTypes
+	w.colKeys = make([][]byte, len(colTypes))
+	for i := range colTypes {
+		if w.colKeys[i], err = json.Marshal(colTypes[i].Name()); err != nil {
+			return
+		}
+	}
+
+	w.rowCount = 0
+	if w.ndjson {
+		return
+	}
+
+	_, err = w.bw.WriteString("[")
+	return
+}
+
+func (w *jsonResultWriter) WriteRow(values []any) (err error) {
+	if w.ndjson {
+		// stream each row out as a complete line:
+		if err = w.writeObject(values); err != nil {
+			return
+		}
+		if err = w.bw.WriteByte('\n'); err != nil {
+			return
+		}
+		return w.bw.Flush()
+	}
+
+	if w.rowCount > 0 {
+		_ = w.bw.WriteByte(',')
+	}
+	w.rowCount++
+	_ = w.bw.WriteByte('\n')
+
+	return w.writeObject(values)
+}
+
+func (w *jsonResultWriter) writeObject(values []any) (err error) {
+	_ = w.bw.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			_ = w.bw.WriteByte(',')
+		}
+		_, _ = w.bw.Write(w.colKeys[i])
+		_ = w.bw.WriteByte(':')
+
+		var b []byte
+		if b, err = w.marshalValue(w.colTypes[i], value); err != nil {
+			return fmt.Errorf("error encoding column %d as JSON: %w", i+1, err)
+		}
+		_, _ = w.bw.Write(b)
+	}
+	_, err = w.bw.WriteString("}")
+	return
+}
+
+func (w *jsonResultWriter) EndResultSet() (err error) {
+	if w.ndjson {
+		return
+	}
+
+	_, err = w.bw.WriteString("\n]\n")
+	return
+}
+
+func (w *jsonResultWriter) SeparateResultSets() (err error) {
+	if w.ndjson {
+		err = w.bw.WriteByte('\n')
+	}
+	return
+}
+
+func (w *jsonResultWriter) EndBatch() error {
+	return w.Flush()
+}
+
+func (w *jsonResultWriter) Flush() error {
+	return w.bw.Flush()
+}
+
+// marshalValue encodes a column value as JSON, keeping numbers and bits unquoted and NULL as null.
+func (w *jsonResultWriter) marshalValue(colType columnType, value any) ([]byte, error) {
+	if value == nil {
+		return []byte("null"), nil
+	}
+
+	// specialize encoding based on type:
+	switch colType.DatabaseTypeName() {
+	case "UNIQUEIDENTIFIER":
+		text, err := uniqueIdentifierText(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(text)
+	case "DECIMAL", "MONEY", "SMALLMONEY":
+		// exact decimal text is emitted as an unquoted JSON number:
+		text, err := formatDecimal(colType, value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(json.Number(text))
+	case "BIT":
+		if b, ok := bitValue(value); ok {
+			return json.Marshal(b)
+		}
+		return json.Marshal(fmt.Sprintf("%v", value))
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return json.Marshal(w.valueFormat.formatBinary(v))
+	case time.Time:
+		return json.Marshal(w.valueFormat.formatTime(colType, v))
+	case string:
+		return json.Marshal(w.valueFormat.formatString(colType, v))
+	default:
+		return json.Marshal(v)
+	}
+}
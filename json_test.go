@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+var jsonTestColumns = []columnType{
+	testColumn{name: "id", typeName: "INT"},
+	testColumn{name: "name", typeName: "NVARCHAR", length: 50, hasLength: true, nullable: true},
+	testColumn{name: "active", typeName: "BIT", nullable: true},
+	testColumn{name: "ratio", typeName: "FLOAT", nullable: true},
+	testColumn{name: "amount", typeName: "DECIMAL", precision: 10, scale: 2, hasDecimal: true, nullable: true},
+}
+
+func TestJSONResultSets(t *testing.T) {
+	newWriter := func(out *bytes.Buffer) resultWriter {
+		return newJSONResultWriter(out, valueFormatter{})
+	}
+	got := writeResultSets(t, newWriter, jsonTestColumns,
+		[][]any{
+			{int64(1), "a \"b\"", true, 1.5, []byte("-10.50")},
+			{int64(2), nil, nil, nil, nil},
+		},
+		[][]any{
+			{int64(3), "c", false, float64(0), []byte("0.00")},
+		},
+	)
+
+	want := `[
+{"id":1,"name":"a \"b\"","active":true,"ratio":1.5,"amount":-10.50},
+{"id":2,"name":null,"active":null,"ratio":null,"amount":null}
+]
+[
+{"id":3,"name":"c","active":false,"ratio":0,"amount":0.00}
+]
+`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNDJSONResultSets(t *testing.T) {
+	newWriter := func(out *bytes.Buffer) resultWriter {
+		return newNDJSONResultWriter(out, valueFormatter{})
+	}
+	got := writeResultSets(t, newWriter, jsonTestColumns,
+		[][]any{
+			{int64(1), "a", true, 1.5, []byte("-10.50")},
+			{int64(2), nil, nil, nil, nil},
+		},
+		[][]any{
+			{int64(3), "c", false, float64(0), []byte("0.00")},
+		},
+	)
+
+	want := `{"id":1,"name":"a","active":true,"ratio":1.5,"amount":-10.50}
+{"id":2,"name":null,"active":null,"ratio":null,"amount":null}
+
+{"id":3,"name":"c","active":false,"ratio":0,"amount":0.00}
+`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -2,171 +2,927 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
-	"encoding/csv"
-	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
-	"github.com/google/uuid"
+	"github.com/golang-sql/sqlexp"
 	_ "github.com/microsoft/go-mssqldb"
 	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/microsoft/go-mssqldb/msdsn"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func main() {
 	csEnv := flag.String("csenv", "", "get sql connection string from this environment variable")
-	csTmpl := flag.String("cs", "", "sql connection string")
-	nullStrValue := flag.String("null", "NULL", "null string representation to use in CSV output")
+	var csList stringsFlag
+	flag.Var(&csList, "cs", "sql connection string (repeatable to run against several servers)")
+	csFile := flag.String("csfile", "", "read sql connection strings from this file, one per line")
+	serverCol := flag.String("servercol", "", "add a leading column of this name holding each row's server name")
+	database := flag.String("db", "", "initial database, overriding any in the connection string")
+	auth := flag.String("auth", "sql", "authentication mode: sql, azuread-default, azuread-msi, or azuread-password (credentials from AZURE_* environment variables)")
+	nullStrValue := flag.String("null", "NULL", "null string representation to use in text output")
+	quoteNull := flag.Bool("quotenull", false, "quote empty strings and literal null-string text so only real NULLs are written bare in CSV output")
 	queryTimeoutSec := flag.Int("t", 60, "query timeout (seconds)")
+	connectTimeout := flag.Duration("connecttimeout", 10*time.Second, "timeout for connecting to and pinging each server, separate from the -t query timeout")
+	timing := flag.Bool("timing", false, "report each batch's row count and elapsed time to stderr")
+	format := flag.String("format", "csv", "output format: csv, json, ndjson, markdown, insert, or raw")
+	batchSep := flag.String("batchsep", "GO", "batch separator token; a line of just this token (optionally followed by a repeat count) executes the batch")
+	inputEnc := flag.String("inputenc", "utf-8", "encoding of query script files and stdin: utf-8 or utf-16le")
+	maxLine := flag.Int("maxline", 64*1024*1024, "maximum length in bytes of a single line of query script input")
+	queryText := flag.String("q", "", "execute this query text as a single batch instead of reading stdin")
+	delim := flag.String("delim", ",", "CSV field delimiter (a single character)")
+	crlf := flag.Bool("crlf", false, "end CSV lines with \\r\\n instead of \\n")
+	tsv := flag.Bool("tsv", false, "write tab-separated values; shorthand for -delim with a tab")
+	headerModeName := flag.String("headermode", "full", "CSV header line: full (names with types), names, or none")
+	noHeader := flag.Bool("noheader", false, "omit the column header line of each CSV result set; shorthand for -headermode none")
+	plainHeader := flag.Bool("plainheader", false, "write just column names in the CSV header; shorthand for -headermode names")
+	trim := flag.Bool("trim", false, "strip trailing spaces from CHAR, NCHAR, VARCHAR, and NVARCHAR values")
+	timeFormat := flag.String("timefmt", "", "Go time layout for date/time values (default ISO 8601 at each column's precision)")
+	var params paramFlags
+	flag.Var(&params, "p", "named query parameter as @name=value or @name:type=value (repeatable; applied to every batch)")
+	maxRows := flag.Int("maxrows", 0, "stop fetching each result set after this many rows and end the batch, failing it under -tx (0 = unlimited)")
+	retries := flag.Int("retries", 0, "retry connecting and executing this many times on transient errors")
+	retryDelay := flag.Duration("retrydelay", time.Second, "initial delay between retries, doubled after each attempt")
+	bitFormat := flag.String("bitformat", "10", "BIT value text: 10 (1/0) or truefalse (true/false)")
+	binaryFormat := flag.String("binary", "hex", "binary value encoding: hex (0x-prefixed) or base64")
+	tx := flag.Bool("tx", false, "run each batch in its own transaction, committed on success and rolled back on any error")
+	table := flag.String("table", "", "target table name for -format insert")
+	expanded := flag.Bool("expanded", false, "write each row as a block of \"ColumnName: value\" lines instead of CSV")
+	explain := flag.Bool("explain", false, "collect each statement's actual execution plan (STATISTICS XML) and write it to stderr instead of the results")
+	planPath := flag.String("planfile", "", "write -explain plans to this file (truncated) instead of stderr; implies -explain")
+	var includeCols, excludeCols stringsFlag
+	flag.Var(&includeCols, "include", "write only this result set column, in the order given (repeatable; case-insensitive)")
+	flag.Var(&excludeCols, "exclude", "omit this result set column (repeatable; case-insensitive)")
+	schemaOnly := flag.Bool("schemaonly", false, "write only each result set's column header, without executing statements or fetching rows (SET FMTONLY ON)")
+	raw := flag.Bool("raw", false, "write each value of single-column result sets on its own line with no header or CSV quoting")
+	outPath := flag.String("o", "", "write output to this file (truncated) instead of stdout")
+	parallel := flag.Int("parallel", 1, "execute up to this many batches at once, writing each batch's output whole in script order")
+	progress := flag.Int("progress", 0, "report a running row count to stderr every this many rows (0 = off)")
+	stats := flag.Bool("stats", false, "report total batches, rows, errors, and elapsed time to stderr at the end of the run")
+	strict := flag.Bool("strict", false, "stop at the first failed batch instead of continuing with the rest")
+	gzipOut := flag.Bool("gzip", false, "gzip-compress the output stream (use with -o or a redirect; not meant for a terminal)")
 
 	flag.Parse()
 
-	connectionString := *csTmpl
-	if connectionString == "" {
-		// fetch name of environment variable:
-		envName := *csEnv
-		if envName == "" {
-			_, _ = fmt.Fprintln(os.Stderr, "missing required sql connection string via -cs or -csenv flag")
+	if *batchSep == "" || strings.ContainsAny(*batchSep, " \t") {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid batch separator '%s' (via -batchsep flag); must be a single non-empty token\n", *batchSep)
+		os.Exit(1)
+	}
+
+	comma, commaSize := utf8.DecodeRuneInString(*delim)
+	if *tsv {
+		comma = '\t'
+	} else if comma == utf8.RuneError || commaSize != len(*delim) || comma == '"' || comma == '\r' || comma == '\n' {
+		_, _ = fmt.Fprintf(os.Stderr, "invalid CSV delimiter '%s' (via -delim flag); must be a single character other than a quote or newline\n", *delim)
+		os.Exit(1)
+	}
+
+	header, ok := parseHeaderMode(*headerModeName)
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "unknown header mode '%s' (via -headermode flag); expected full, names, or none\n", *headerModeName)
+		os.Exit(1)
+	}
+	if *noHeader && *plainHeader {
+		_, _ = fmt.Fprintln(os.Stderr, "-noheader and -plainheader flags are mutually exclusive")
+		os.Exit(1)
+	} else if (*noHeader || *plainHeader) && header != headerFull {
+		_, _ = fmt.Fprintln(os.Stderr, "-noheader and -plainheader flags cannot be combined with -headermode")
+		os.Exit(1)
+	} else if *noHeader {
+		header = headerNone
+	} else if *plainHeader {
+		header = headerNames
+	}
+
+	retry := retryPolicy{
+		retries: *retries,
+		delay:   *retryDelay,
+	}
+
+	var err error
+
+	if *planPath != "" {
+		// -planfile implies -explain:
+		*explain = true
+	}
+	if *schemaOnly && (*explain || *planPath != "") {
+		_, _ = fmt.Fprintln(os.Stderr, "-schemaonly flag cannot be combined with -explain or -planfile")
+		os.Exit(1)
+	}
+
+	valueFormat := valueFormatter{
+		timeFormat:  *timeFormat,
+		trimStrings: *trim,
+	}
+	switch *binaryFormat {
+	case "hex":
+	case "base64":
+		valueFormat.binaryBase64 = true
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown binary encoding '%s' (via -binary flag); expected hex or base64\n", *binaryFormat)
+		os.Exit(1)
+	}
+	switch *bitFormat {
+	case "10":
+	case "truefalse":
+		valueFormat.bitTrueFalse = true
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown bit format '%s' (via -bitformat flag); expected 10 or truefalse\n", *bitFormat)
+		os.Exit(1)
+	}
+
+	if *connectTimeout <= 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "-connecttimeout flag must be a positive duration")
+		os.Exit(1)
+	}
+
+	if *parallel < 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "-parallel flag must be at least 1")
+		os.Exit(1)
+	} else if *parallel > 1 && *progress > 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "-progress flag cannot be combined with -parallel")
+		os.Exit(1)
+	}
+
+	if *maxLine < 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "-maxline flag must be a positive number of bytes")
+		os.Exit(1)
+	}
+
+	// decode scripts to UTF-8; both decoders drop a leading byte order mark:
+	var inputEncoding encoding.Encoding
+	switch strings.ToLower(*inputEnc) {
+	case "utf-8", "utf8":
+		inputEncoding = unicode.UTF8BOM
+	case "utf-16le", "utf16le":
+		inputEncoding = unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown input encoding '%s' (via -inputenc flag); expected utf-8 or utf-16le\n", *inputEnc)
+		os.Exit(1)
+	}
+
+	if *expanded {
+		if *format != "csv" {
+			_, _ = fmt.Fprintln(os.Stderr, "-expanded flag cannot be combined with a -format other than csv")
+			os.Exit(1)
+		}
+		*format = "expanded"
+	}
+	if *raw {
+		if *format != "csv" {
+			_, _ = fmt.Fprintln(os.Stderr, "-raw flag cannot be combined with -expanded or a -format other than csv")
 			os.Exit(1)
 		}
+		if *serverCol != "" {
+			_, _ = fmt.Fprintln(os.Stderr, "-raw flag cannot be combined with -servercol")
+			os.Exit(1)
+		}
+		*format = "raw"
+	}
 
-		connectionString = os.Getenv(envName)
+	// -parallel needs a separate writer per batch, so construct writers on demand:
+	var newWriter func(out io.Writer) resultWriter
+	switch *format {
+	case "csv":
+		newWriter = func(out io.Writer) resultWriter {
+			return newCSVResultWriter(out, csvOptions{
+				nullString:  *nullStrValue,
+				quoteNull:   *quoteNull,
+				comma:       comma,
+				useCRLF:     *crlf,
+				header:      header,
+				valueFormat: valueFormat,
+			})
+		}
+	case "json":
+		newWriter = func(out io.Writer) resultWriter { return newJSONResultWriter(out, valueFormat) }
+	case "ndjson":
+		newWriter = func(out io.Writer) resultWriter { return newNDJSONResultWriter(out, valueFormat) }
+	case "markdown":
+		newWriter = func(out io.Writer) resultWriter { return newMarkdownResultWriter(out, *nullStrValue, valueFormat) }
+	case "expanded":
+		newWriter = func(out io.Writer) resultWriter { return newExpandedResultWriter(out, *nullStrValue, valueFormat) }
+	case "raw":
+		newWriter = func(out io.Writer) resultWriter { return newRawResultWriter(out, *nullStrValue, valueFormat) }
+	case "insert":
+		if *table == "" {
+			_, _ = fmt.Fprintln(os.Stderr, "missing required target table name via -table flag for -format insert")
+			os.Exit(1)
+		}
+		newWriter = func(out io.Writer) resultWriter { return newInsertResultWriter(out, *table) }
+	default:
+		_, _ = fmt.Fprintf(os.Stderr, "unknown output format '%s' (via -format flag); expected csv, json, ndjson, markdown, insert, or raw\n", *format)
+		os.Exit(1)
+	}
+
+	// exactly one connection string source is required:
+	sources := 0
+	for _, source := range []string{strings.Join(csList, ""), *csEnv, *csFile} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "missing required sql connection string via -cs, -csenv, or -csfile flag")
+		os.Exit(1)
+	} else if sources > 1 {
+		_, _ = fmt.Fprintln(os.Stderr, "only one of -cs, -csenv, or -csfile flags may be supplied")
+		os.Exit(1)
+	}
+
+	connectionStrings := []string(csList)
+	if envName := *csEnv; envName != "" {
+		connectionString := os.Getenv(envName)
 		if connectionString == "" {
 			_, _ = fmt.Fprintf(os.Stderr, "missing required sql connection string from environment variable '%s' (via -csenv flag)\n", envName)
 			os.Exit(1)
 		}
+		connectionStrings = []string{connectionString}
+	} else if *csFile != "" {
+		var b []byte
+		if b, err = os.ReadFile(*csFile); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error reading sql connection string from file '%s' (via -csfile flag): %v\n", *csFile, err)
+			os.Exit(1)
+		}
+
+		// one connection string per non-blank line:
+		for _, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+				connectionStrings = append(connectionStrings, line)
+			}
+		}
+		if len(connectionStrings) == 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "missing required sql connection string from file '%s' (via -csfile flag)\n", *csFile)
+			os.Exit(1)
+		}
 	}
 
-	var err error
-	var c *sql.DB
-	if c, err = sql.Open(
-		"sqlserver",
-		connectionString,
-	); err != nil {
+	// cancel the running query on the first interrupt and force exit on the second:
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, os.Interrupt)
+
+		<-sigs
+		_, _ = fmt.Fprintln(os.Stderr, "-- interrupted; canceling query (interrupt again to force exit)")
+		cancel()
+
+		<-sigs
+		os.Exit(130)
+	}()
+
+	var stdin io.Reader = os.Stdin
+	if len(connectionStrings) > 1 && flag.NArg() == 0 && *queryText == "" {
+		// stdin can only be read once, so keep the script to replay it for each server:
+		var b []byte
+		if b, err = io.ReadAll(os.Stdin); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		stdin = bytes.NewReader(b)
+	}
+
+	// create output files only once every flag has been validated, so a mistake
+	// does not truncate the previous output:
+	var out io.Writer = os.Stdout
+	var outFile *os.File
+	if *outPath != "" {
+		if outFile, err = os.Create(*outPath); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error creating output file '%s' (via -o flag): %v\n", *outPath, err)
+			os.Exit(1)
+		}
+		out = outFile
+	}
+
+	// plans go to stderr unless a -planfile is given:
+	var planOut io.Writer = os.Stderr
+	var planFile *os.File
+	if *planPath != "" {
+		if planFile, err = os.Create(*planPath); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error creating plan file '%s' (via -planfile flag): %v\n", *planPath, err)
+			os.Exit(1)
+		}
+		planOut = planFile
+	}
+
+	// compress the output stream if requested:
+	var gz *gzip.Writer
+	if *gzipOut {
+		if outFile == nil {
+			if fi, statErr := os.Stdout.Stat(); statErr == nil && fi.Mode()&os.ModeCharDevice != 0 {
+				_, _ = fmt.Fprintln(os.Stderr, "warning: writing gzip output to a terminal; redirect stdout or use -o")
+			}
+		}
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+
+	rw := newWriter(out)
+
+	// execute queries and write results in the chosen format:
+	q := &queryCSV{
+		rw:           rw,
+		newWriter:    newWriter,
+		out:          out,
+		stderr:       os.Stderr,
+		parallel:     *parallel,
+		queryTimeout: time.Second * time.Duration(*queryTimeoutSec),
+		connTimeout:  *connectTimeout,
+		timing:       *timing,
+		batchSep:     *batchSep,
+		inputEnc:     inputEncoding,
+		maxLine:      *maxLine,
+		params:       params,
+		maxRows:      *maxRows,
+		progress:     *progress,
+		retry:        retry,
+		tx:           *tx,
+		explain:      *explain,
+		schemaOnly:   *schemaOnly,
+		planOut:      planOut,
+		strict:       *strict,
+		serverColumn: *serverCol,
+		includeCols:  includeCols,
+		excludeCols:  excludeCols,
+		labelServer:  len(connectionStrings) > 1,
+	}
+
+	runStart := time.Now()
+	exitCode := 0
+	for i, connectionString := range connectionStrings {
+		if ctx.Err() != nil {
+			break
+		}
+
+		q.server = serverLabel(connectionString, i)
+		if r, ok := stdin.(*bytes.Reader); ok {
+			_, _ = r.Seek(0, io.SeekStart)
+		}
+
+		// failures on one server do not stop the others unless -strict:
+		if err = q.execServer(ctx, connectionString, *auth, *database, *queryText, flag.Args(), stdin); errors.Is(err, errStrictAbort) {
+			_, _ = fmt.Fprintln(os.Stderr, "-- aborting after failed batch (-strict)")
+			break
+		} else if err != nil {
+			if q.labelServer {
+				_, _ = fmt.Fprintf(os.Stderr, "server %s: %v\n", q.server, err)
+			} else {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+			}
+			q.stats.errors++
+			exitCode = 1
+			if q.strict {
+				break
+			}
+		}
+	}
+	if q.failed {
+		exitCode = 1
+	}
+
+	// flush and close the output; the gzip stream must be closed after the final flush:
+	if err = rw.Flush(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if gz != nil {
+		if err = gz.Close(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error closing gzip output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if outFile != nil {
+		if err = outFile.Close(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error closing output file '%s': %v\n", *outPath, err)
+			os.Exit(1)
+		}
+	}
+	if planFile != nil {
+		if err = planFile.Close(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error closing plan file '%s': %v\n", *planPath, err)
+			os.Exit(1)
+		}
+	}
+
+	if *stats {
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"-- %s, %s, %s in %s\n",
+			formatCount(q.stats.batches, "batch", "batches"),
+			formatRowCount(q.stats.rows),
+			formatCount(q.stats.errors, "error", "errors"),
+			formatElapsed(time.Since(runStart)),
+		)
+	}
+
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// runStats accumulates totals across all batches for the -stats summary.
+type runStats struct {
+	batches int
+	rows    int
+	errors  int
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+type queryCSV struct {
+	c            *sql.DB
+	rw           resultWriter
+	newWriter    func(out io.Writer) resultWriter
+	out          io.Writer
+	stderr       io.Writer
+	parallel     int
+	pool         *batchPool
+	queryTimeout time.Duration
+	connTimeout  time.Duration
+	timing       bool
+	batchSep     string
+	inputEnc     encoding.Encoding
+	maxLine      int
+	params       []any
+	maxRows      int
+	progress     int
+	retry        retryPolicy
+	tx           bool
+	explain      bool
+	schemaOnly   bool
+	planOut      io.Writer
+	strict       bool
+	failed       bool
+	stats        runStats
+	server       string
+	serverColumn string
+	includeCols  []string
+	excludeCols  []string
+	labelServer  bool
+}
+
+// execServer connects to a server and executes the -q query text, then each file argument,
+// or else the script read from stdin.
+func (q *queryCSV) execServer(ctx context.Context, connectionString string, auth string, database string, queryText string, paths []string, stdin io.Reader) (err error) {
+	var connector *mssql.Connector
+	if connector, err = newConnector(connectionString, auth, database, q.connTimeout); err != nil {
+		return
+	}
+	if q.explain {
+		// every pooled session returns actual plans alongside results:
+		connector.SessionInitSQL = "SET STATISTICS XML ON;"
+	} else if q.schemaOnly {
+		// every pooled session returns result set metadata without executing statements:
+		connector.SessionInitSQL = "SET FMTONLY ON;"
+	}
+
+	q.c = sql.OpenDB(connector)
 	defer func(c *sql.DB) {
 		_ = c.Close()
-	}(c)
+	}(q.c)
 
-	{
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	// test database connectivity with a quick Ping():
+	if err = q.retry.do(ctx, q.stderr, "connect", func() error {
+		ctx, cancel := context.WithTimeout(ctx, q.connTimeout)
+		defer cancel()
 
-		// test database connectivity with a quick Ping():
-		if err = c.PingContext(ctx); err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+		return q.c.PingContext(ctx)
+	}); err != nil {
+		var netErr net.Error
+		if ctx.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()) {
+			// distinguish a slow or unreachable server from a slow query:
+			err = fmt.Errorf("connection timed out after %s: %w", q.connTimeout, err)
 		}
+		return
+	}
 
-		cancel()
+	if q.parallel > 1 {
+		// wait for every batch to finish before disconnecting:
+		q.pool = newBatchPool(q, q.parallel)
+		defer func() {
+			q.pool.wait()
+			q.pool = nil
+		}()
+	}
+
+	if queryText != "" {
+		// execute the inline query as a single batch:
+		if !q.runBatch(ctx, queryText, q.queryTimeout) && q.strict {
+			return errStrictAbort
+		}
+	}
+
+	if len(paths) > 0 {
+		// read query text from each file argument in order:
+		for _, path := range paths {
+			if ctx.Err() != nil {
+				break
+			}
+			if err = q.execScriptFile(ctx, path); err != nil {
+				return
+			}
+		}
+	} else if queryText == "" {
+		// read all query text from stdin:
+		if err = q.execScript(ctx, stdin); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// execScriptFile reads query text from the named file and executes each GO-terminated batch.
+func (q *queryCSV) execScriptFile(ctx context.Context, path string) (err error) {
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return fmt.Errorf("error opening query file '%s': %w", path, err)
+	}
+	defer func(f *os.File) {
+		_ = f.Close()
+	}(f)
+
+	if err = q.execScript(ctx, f); errors.Is(err, errStrictAbort) {
+		return
+	} else if err != nil {
+		return fmt.Errorf("error reading query file '%s': %w", path, err)
+	}
+
+	return
+}
+
+// serverLabel names the server a connection string points at, for labeling output.
+func serverLabel(connectionString string, index int) string {
+	config, err := msdsn.Parse(connectionString)
+	if err != nil || config.Host == "" {
+		return fmt.Sprintf("#%d", index+1)
+	}
+
+	label := config.Host
+	if config.Instance != "" {
+		label += `\` + config.Instance
+	}
+	if config.Port != 0 {
+		label += "," + strconv.FormatUint(config.Port, 10)
+	}
+	return label
+}
+
+// stringsFlag collects the values of a repeatable string flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// parseBatchSeparator reports whether line is a batch separator (e.g. "GO" or "GO 5")
+// and how many times the preceding batch should be executed.
+func (q *queryCSV) parseBatchSeparator(line string) (count int, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 1 || len(fields) > 2 {
+		return
+	}
+	if strings.ToUpper(fields[0]) != strings.ToUpper(q.batchSep) {
+		return
+	}
+
+	if len(fields) == 1 {
+		return 1, true
 	}
 
+	// "GO <count>" executes the batch count times:
+	var err error
+	if count, err = strconv.Atoi(fields[1]); err != nil || count < 1 {
+		return 0, false
+	}
+	return count, true
+}
+
+// execScript reads query text from r and executes each GO-terminated batch.
+// It stops early without error once ctx is canceled.
+func (q *queryCSV) execScript(ctx context.Context, r io.Reader) error {
 	var text strings.Builder
+	var lexer batchLexer
+	timeout := q.queryTimeout
 
-	// read all query text from stdin:
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
+	scanner := bufio.NewScanner(transform.NewReader(r, q.inputEnc.NewDecoder()))
+	scanner.Buffer(make([]byte, 0, min(64*1024, q.maxLine)), q.maxLine)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
 		line := scanner.Text()
 
-		// ready to execute?
-		if strings.ToUpper(strings.TrimSpace(line)) == "GO" {
-			// start a CSV writer:
-			cw := csv.NewWriter(os.Stdout)
-
-			// execute the query and write CSV output:
-			q := &queryCSV{
-				c:            c,
-				cw:           cw,
-				nullString:   *nullStrValue,
-				queryTimeout: time.Second * time.Duration(*queryTimeoutSec),
-			}
-			err = q.execQuery(text.String())
-
-			// make sure CSV flushes to stdout:
-			cw.Flush()
+		// separators and directives inside a string, bracketed name, or comment are just text:
+		open := lexer.open()
+		count, isSeparator := q.parseBatchSeparator(line)
+		if isSeparator && open != "" {
+			q.warnf("-- line %d: %s inside an unterminated %s does not end the batch\n", lineNumber, strings.TrimSpace(line), open)
+		}
 
-			// handle any errors:
-			if err != nil {
-				var sqlErr mssql.Error
-				if errors.As(err, &sqlErr) {
-					// SQL server error:
-					_, _ = fmt.Fprintf(os.Stderr, "%#v\n", sqlErr)
-				} else {
-					_, _ = fmt.Fprintln(os.Stderr, err)
+		// ready to execute?
+		if isSeparator && open == "" {
+			for i := 0; i < count && ctx.Err() == nil; i++ {
+				if !q.runBatch(ctx, text.String(), timeout) && q.strict {
+					return errStrictAbort
 				}
 			}
+			if ctx.Err() != nil {
+				return nil
+			}
 
 			// prepare for next query:
 			text.Reset()
-			_, _ = os.Stdout.WriteString("---\n")
+			lexer.reset()
+			timeout = q.queryTimeout
+		} else if key, value, ok := parseDirective(line); ok && open == "" {
+			// directives apply to the next batch only and are not sent to the server:
+			switch key {
+			case "timeout":
+				seconds, err := strconv.Atoi(value)
+				if err != nil || seconds <= 0 {
+					q.warnf("-- ignoring invalid sqlq:timeout directive value '%s'\n", value)
+					break
+				}
+				timeout = time.Second * time.Duration(seconds)
+			default:
+				q.warnf("-- ignoring unknown sqlq directive '%s'\n", key)
+			}
 		} else {
 			// nope; append line to text:
 			text.WriteString(line)
 			text.WriteString("\r\n")
+			lexer.scanLine(line)
 		}
 	}
 
-	if err = scanner.Err(); err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+	if err := scanner.Err(); errors.Is(err, bufio.ErrTooLong) {
+		return fmt.Errorf("%w (longer than the -maxline limit of %d bytes)", err, q.maxLine)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// warnf writes a script warning to stderr, after the output of any batches still running in the
+// -parallel pool.
+func (q *queryCSV) warnf(format string, args ...any) {
+	if q.pool != nil {
+		q.pool.note(fmt.Sprintf(format, args...))
+		return
 	}
+	_, _ = fmt.Fprintf(q.stderr, format, args...)
 }
 
-type csvWriter interface {
-	Write(record []string) error
+// parseDirective parses a "-- sqlq:key=value" comment line.
+func parseDirective(line string) (key string, value string, ok bool) {
+	comment, isComment := strings.CutPrefix(strings.TrimSpace(line), "--")
+	if !isComment {
+		return
+	}
+
+	directive, isDirective := strings.CutPrefix(strings.TrimSpace(comment), "sqlq:")
+	if !isDirective {
+		return
+	}
+
+	key, value, _ = strings.Cut(directive, "=")
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
 }
 
-type queryCSV struct {
-	c            *sql.DB
-	cw           csvWriter
-	nullString   string
-	queryTimeout time.Duration
+// runBatch executes a batch, or submits it to the -parallel pool. It reports false on failure;
+// with a pool, that means some batch submitted so far has failed.
+func (q *queryCSV) runBatch(ctx context.Context, text string, timeout time.Duration) bool {
+	if q.pool != nil {
+		return q.pool.submit(ctx, text, timeout)
+	}
+	return q.execBatch(ctx, text, timeout)
 }
 
-func (q *queryCSV) execQuery(text string) (err error) {
-	var rows *sql.Rows
+// errStrictAbort stops script execution after the first failed batch when -strict is set.
+var errStrictAbort = errors.New("aborting after failed batch")
 
-	ctx, cancel := context.WithTimeout(context.Background(), q.queryTimeout)
-	defer cancel()
+// execBatch executes a single batch, writes its output, and reports any errors to stderr.
+// It reports whether the batch succeeded; any failure is also recorded in q.failed.
+func (q *queryCSV) execBatch(ctx context.Context, text string, timeout time.Duration) (ok bool) {
+	if q.labelServer {
+		// label which server this block of output came from:
+		if err := q.rw.WriteComment("server: " + q.server); err != nil {
+			_, _ = fmt.Fprintln(q.stderr, err)
+		}
+	}
 
-	tStart := time.Now()
-	rows, err = q.c.QueryContext(
-		ctx,
-		text,
-	)
-	tEnd := time.Now()
+	// execute the query and write output:
+	err := q.execQuery(ctx, text, timeout)
 
-	_, _ = tStart, tEnd
+	// make sure output flushes:
+	if flushErr := q.rw.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
 
+	// handle any errors:
+	q.stats.batches++
+	ok = err == nil
 	if err != nil {
-		return fmt.Errorf("error executing query: %w", err)
+		reportError(q.stderr, err)
+		q.failed = true
+		q.stats.errors++
 	}
 
-nextResultSet:
-	var colTypes []*sql.ColumnType
-	if colTypes, err = rows.ColumnTypes(); err != nil {
-		return fmt.Errorf("error fetching column schema: %w", err)
+	if err = q.rw.EndBatch(); err != nil {
+		_, _ = fmt.Fprintln(q.stderr, err)
 	}
+	return
+}
 
-	if len(colTypes) > 0 {
-		if err = q.writeResultSet(colTypes, rows); err != nil {
-			return
+// reportError writes err to w, writing each joined error on its own line.
+func reportError(w io.Writer, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			reportError(w, err)
 		}
+		return
 	}
 
-	if rows.NextResultSet() {
-		// separate result sets from each other:
-		if err = q.cw.Write(nil); err != nil {
-			return fmt.Errorf("error writing CSV result set separator: %w", err)
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		// SQL server error:
+		_, _ = fmt.Fprintf(w, "%#v\n", sqlErr)
+	} else {
+		_, _ = fmt.Fprintln(w, err)
+	}
+}
+
+func (q *queryCSV) execQuery(ctx context.Context, text string, timeout time.Duration) (err error) {
+	var rows *sql.Rows
+	var retmsg *sqlexp.ReturnMessage
+	var totalRows int
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	tStart := time.Now()
+
+	var db queryer = q.c
+	if q.tx {
+		// run the whole batch in a transaction bound by the query timeout:
+		var tx *sql.Tx
+		if tx, err = q.c.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("error beginning transaction: %w", err)
 		}
+		defer func(tx *sql.Tx) {
+			if err != nil || ctx.Err() != nil {
+				_ = tx.Rollback()
+				if err == nil {
+					_, _ = fmt.Fprintln(q.stderr, "-- transaction rolled back")
+				}
+				return
+			}
 
-		goto nextResultSet
+			if err = tx.Commit(); err != nil {
+				err = fmt.Errorf("error committing transaction: %w", err)
+			}
+		}(tx)
+		db = tx
+	}
+
+	err = q.retry.do(ctx, q.stderr, "query", func() (err error) {
+		// receive PRINT/RAISERROR messages alongside result sets:
+		retmsg = &sqlexp.ReturnMessage{}
+		rows, err = db.QueryContext(
+			ctx,
+			text,
+			append([]any{retmsg}, q.params...)...,
+		)
+		return
+	})
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil {
+		return fmt.Errorf("query timed out after %s: %w", timeout, err)
+	} else if err != nil {
+		return fmt.Errorf("error executing query: %w", err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	// SQL errors are delivered as messages rather than returned from QueryContext:
+	var sqlErrs []error
+
+	resultSets := 0
+	inResultSet := false
+	for active := true; active; {
+		switch m := retmsg.Message(ctx).(type) {
+		case sqlexp.MsgNotice:
+			// flush first so the message lines up with the output preceding it:
+			if err = q.rw.Flush(); err != nil {
+				return
+			}
+			_, _ = fmt.Fprintf(q.stderr, "-- %s\n", m.Message.String())
+		case sqlexp.MsgError:
+			sqlErrs = append(sqlErrs, m.Error)
+		case sqlexp.MsgRowsAffected:
+			if inResultSet {
+				// the count of rows in a result set we already wrote:
+				break
+			}
+
+			// INSERT/UPDATE/DELETE/MERGE and friends produce no result set:
+			if err = q.rw.Flush(); err != nil {
+				return
+			}
+			_, _ = fmt.Fprintf(q.stderr, "-- %s affected\n", formatRowCount(int(m.Count)))
+		case sqlexp.MsgNextResultSet:
+			inResultSet = false
+			active = rows.NextResultSet()
+		case sqlexp.MsgNext:
+			var colTypes []*sql.ColumnType
+			if colTypes, err = rows.ColumnTypes(); err != nil {
+				return fmt.Errorf("error fetching column schema: %w", err)
+			}
+			if len(colTypes) == 0 {
+				break
+			}
+
+			if q.explain && isPlanResultSet(colTypes) {
+				// keep plans out of the result stream:
+				inResultSet = true
+				if err = q.writePlan(rows); err != nil {
+					return
+				}
+				break
+			}
+
+			if resultSets > 0 {
+				// separate result sets from each other:
+				if err = q.rw.SeparateResultSets(); err != nil {
+					return fmt.Errorf("error writing result set separator: %w", err)
+				}
+			}
+			resultSets++
+			inResultSet = true
+
+			if isForResultSet(colTypes) {
+				// FOR JSON/XML documents are written as-is, without a header:
+				if err = q.writeForResult(rows); err != nil {
+					return
+				}
+				break
+			}
+
+			var rowCount int
+			var truncated bool
+			rowCount, truncated, err = q.writeResultSet(colTypes, rows)
+			totalRows += rowCount
+			q.stats.rows += rowCount
+			if err != nil {
+				return
+			}
+
+			if truncated {
+				// cancel so the server stops producing rows rather than draining them:
+				cancel()
+				_ = rows.Close()
+
+				if err = q.rw.Flush(); err != nil {
+					return
+				}
+				_, _ = fmt.Fprintf(q.stderr, "-- truncated at %s\n", formatRowCount(rowCount))
+
+				if q.tx {
+					// canceling rolled back the batch's changes, so it did not succeed:
+					return fmt.Errorf("transaction rolled back after result set was truncated (via -maxrows flag)")
+				}
+				return q.reportTiming(tStart, totalRows)
+			}
+		}
+	}
+
+	if len(sqlErrs) > 0 {
+		return errors.Join(sqlErrs...)
 	}
 
 	if err = rows.Close(); err != nil {
@@ -177,114 +933,231 @@ nextResultSet:
 		return fmt.Errorf("error from result set: %w", err)
 	}
 
+	return q.reportTiming(tStart, totalRows)
+}
+
+// reportTiming writes the batch's row count and elapsed time to stderr when -timing is enabled.
+func (q *queryCSV) reportTiming(tStart time.Time, totalRows int) (err error) {
+	if !q.timing {
+		return
+	}
+
+	elapsed := time.Since(tStart)
+
+	// flush first so the timing line follows the batch's output:
+	if err = q.rw.Flush(); err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(q.stderr, "-- %s in %s\n", formatRowCount(totalRows), formatElapsed(elapsed))
+
 	return
 }
 
-func (q *queryCSV) writeResultSet(colTypes []*sql.ColumnType, rows *sql.Rows) (err error) {
-	// write the CSV header:
-	colNames := q.writeHeader(colTypes)
-	if err = q.cw.Write(colNames); err != nil {
-		return fmt.Errorf("error writing CSV column header: %w", err)
+// planColumnName is the sole column of the result set carrying a STATISTICS XML plan.
+const planColumnName = "Microsoft SQL Server 2005 XML Showplan"
+
+// isPlanResultSet reports whether a result set is a STATISTICS XML plan.
+func isPlanResultSet(colTypes []*sql.ColumnType) bool {
+	return len(colTypes) == 1 && colTypes[0].Name() == planColumnName
+}
+
+// writePlan writes each plan in the current result set to the plan output, one per line.
+func (q *queryCSV) writePlan(rows *sql.Rows) (err error) {
+	// flush first so the plan follows the output of the statement it describes:
+	if err = q.rw.Flush(); err != nil {
+		return
+	}
+
+	for rows.Next() {
+		var plan string
+		if err = rows.Scan(&plan); err != nil {
+			return fmt.Errorf("error scanning query plan: %w", err)
+		}
+		if _, err = fmt.Fprintln(q.planOut, plan); err != nil {
+			return fmt.Errorf("error writing query plan: %w", err)
+		}
+	}
+
+	return
+}
+
+// forJSONColumnName and forXMLColumnName name the sole column of a FOR JSON or FOR XML result
+// set, which streams one document split across rows.
+const (
+	forJSONColumnName = "JSON_F52E2B61-18A1-11d1-B105-00805F49916B"
+	forXMLColumnName  = "XML_F52E2B61-18A1-11d1-B105-00805F49916B"
+)
+
+// isForResultSet reports whether a result set is the output of FOR JSON or FOR XML.
+func isForResultSet(colTypes []*sql.ColumnType) bool {
+	return len(colTypes) == 1 && (colTypes[0].Name() == forJSONColumnName || colTypes[0].Name() == forXMLColumnName)
+}
+
+// writeForResult concatenates the chunks of a FOR JSON or FOR XML result set and writes the
+// document straight to the output, followed by a newline.
+func (q *queryCSV) writeForResult(rows *sql.Rows) (err error) {
+	// flush first so the document follows the output preceding it:
+	if err = q.rw.Flush(); err != nil {
+		return
+	}
+
+	for rows.Next() {
+		var chunk sql.NullString
+		if err = rows.Scan(&chunk); err != nil {
+			return fmt.Errorf("error scanning FOR JSON/XML result: %w", err)
+		}
+		if _, err = io.WriteString(q.out, chunk.String); err != nil {
+			return fmt.Errorf("error writing FOR JSON/XML result: %w", err)
+		}
+	}
+
+	_, err = io.WriteString(q.out, "\n")
+	return
+}
+
+// projectColumns returns the indexes of the result set columns to write, in order, after
+// applying -include and -exclude.
+func (q *queryCSV) projectColumns(colTypes []*sql.ColumnType) (projection []int, err error) {
+	if len(q.includeCols) > 0 {
+		// only the included columns, in the order requested:
+		for _, name := range q.includeCols {
+			i := slices.IndexFunc(colTypes, func(colType *sql.ColumnType) bool {
+				return strings.EqualFold(colType.Name(), name)
+			})
+			if i < 0 {
+				return nil, fmt.Errorf("included column '%s' (via -include flag) is not in the result set", name)
+			}
+			projection = append(projection, i)
+		}
+	} else {
+		for i := range colTypes {
+			projection = append(projection, i)
+		}
+	}
+
+	// drop any excluded columns:
+	return slices.DeleteFunc(projection, func(i int) bool {
+		return slices.ContainsFunc(q.excludeCols, func(name string) bool {
+			return strings.EqualFold(colTypes[i].Name(), name)
+		})
+	}), nil
+}
+
+// writeResultSet writes the current result set's header and rows. It reports truncated
+// when more than -maxrows rows were available and it stopped early.
+func (q *queryCSV) writeResultSet(sqlColTypes []*sql.ColumnType, rows *sql.Rows) (rowCount int, truncated bool, err error) {
+	// select the columns to write:
+	var projection []int
+	if projection, err = q.projectColumns(sqlColTypes); err != nil {
+		return
+	}
+
+	// optionally prepend a synthetic column naming the server:
+	var colTypes []columnType
+	if q.serverColumn != "" {
+		colTypes = append(colTypes, serverColumn{name: q.serverColumn})
+	}
+	offset := len(colTypes)
+	for _, i := range projection {
+		colTypes = append(colTypes, sqlColTypes[i])
+	}
+
+	// write the result set header:
+	if err = q.rw.BeginResultSet(colTypes); err != nil {
+		err = fmt.Errorf("error writing column header: %w", err)
+		return
 	}
 
-	formatted := make([]string, len(colTypes))
-	rowValues := make([]any, len(colTypes))
-	for rowCount := 0; rows.Next(); rowCount++ {
+	if q.schemaOnly {
+		// just the header:
+		if err = q.rw.EndResultSet(); err != nil {
+			err = fmt.Errorf("error writing result set footer: %w", err)
+		}
+		return
+	}
+
+	// keep a running row count on stderr, finished with a newline:
+	tStart := time.Now()
+	if q.progress > 0 {
+		defer func() {
+			if rowCount >= q.progress {
+				_, _ = fmt.Fprintf(q.stderr, "\r-- %s written in %s\n", formatRowCount(rowCount), formatElapsed(time.Since(tStart)))
+			}
+		}()
+	}
+
+	values := make([]any, len(colTypes))
+	if offset > 0 {
+		values[0] = q.server
+	}
+	rowValues := make([]any, len(sqlColTypes))
+	for ; rows.Next(); rowCount++ {
+		if q.maxRows > 0 && rowCount >= q.maxRows {
+			truncated = true
+			break
+		}
+
 		// fetch column values:
 		for i := range rowValues {
 			rowValues[i] = new(any)
 		}
 		if err = rows.Scan(rowValues...); err != nil {
-			return fmt.Errorf("error in row %d scanning: %w", rowCount+1, err)
+			err = fmt.Errorf("error in row %d scanning: %w", rowCount+1, err)
+			return
+		}
+		for j, i := range projection {
+			values[offset+j] = *rowValues[i].(*any)
 		}
 
-		// format column values for output to CSV:
-		for i := range rowValues {
-			value := *rowValues[i].(*any)
-			if value == nil {
-				formatted[i] = q.nullString
-				continue
-			}
-
-			// specialize formatting based on type:
-			switch colTypes[i].DatabaseTypeName() {
-			case "UNIQUEIDENTIFIER":
-				var uv uuid.UUID
-				uv, err = uuid.FromBytes(value.([]byte))
-				if err != nil {
-					return fmt.Errorf("error in row %d constructing uuid from bytes: %w", rowCount+1, err)
-				}
-				formatted[i] = uv.String()
-			case "DECIMAL":
-			case "MONEY":
-				formatted[i] = string(value.([]byte))
-			case "BIT":
-				if value.(bool) {
-					formatted[i] = "1"
-				} else {
-					formatted[i] = "0"
-				}
-			default:
-				switch v := value.(type) {
-				case []byte:
-					formatted[i] = "0x" + hex.EncodeToString(v)
-				default:
-					formatted[i] = fmt.Sprintf("%v", v)
-				}
-			}
+		// write the formatted row:
+		if err = q.rw.WriteRow(values); err != nil {
+			err = fmt.Errorf("error in row %d writing: %w", rowCount+1, err)
+			return
 		}
 
-		// write the CSV line:
-		if err = q.cw.Write(formatted); err != nil {
-			return fmt.Errorf("error in row %d writing CSV: %w", rowCount+1, err)
+		if q.progress > 0 && (rowCount+1)%q.progress == 0 {
+			_, _ = fmt.Fprintf(q.stderr, "\r-- %s written in %s", formatRowCount(rowCount+1), formatElapsed(time.Since(tStart)))
 		}
 	}
 
+	if err = q.rw.EndResultSet(); err != nil {
+		err = fmt.Errorf("error writing result set footer: %w", err)
+		return
+	}
+
 	return
 }
 
-func (q *queryCSV) writeHeader(colTypes []*sql.ColumnType) (colNames []string) {
-	colNames = make([]string, len(colTypes))
-
-	// output column header including types:
-	for i := range colTypes {
-		nullable, hasNullable := colTypes[i].Nullable()
-		length, hasLength := colTypes[i].Length()
-		decimalSize, decimalScale, hasDecimalSize := colTypes[i].DecimalSize()
-
-		sb := strings.Builder{}
-		colName := colTypes[i].Name()
-		/*if colName != ""*/ {
-			sb.WriteRune('[')
-			sb.WriteString(strings.ReplaceAll(colName, "]", "]]"))
-			sb.WriteRune(']')
-			sb.WriteRune(' ')
-		}
-		sb.WriteString(colTypes[i].DatabaseTypeName())
-		if hasLength {
-			sb.WriteRune('(')
-			if length == 2147483645 || length == 1073741822 {
-				sb.WriteString("max")
-			} else {
-				sb.WriteString(strconv.FormatInt(length, 10))
-			}
-			sb.WriteRune(')')
-		} else if hasDecimalSize {
-			sb.WriteRune('(')
-			sb.WriteString(strconv.FormatInt(decimalSize, 10))
+// formatRowCount formats a row count with thousands separators, e.g. "1,234 rows".
+func formatRowCount(n int) string {
+	return formatCount(n, "row", "rows")
+}
+
+// formatCount formats a count with thousands separators followed by the singular or plural noun.
+func formatCount(n int, singular string, plural string) string {
+	digits := strconv.Itoa(n)
+
+	sb := strings.Builder{}
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
 			sb.WriteRune(',')
-			sb.WriteString(strconv.FormatInt(decimalScale, 10))
-			sb.WriteRune(')')
-		}
-		if hasNullable {
-			sb.WriteRune(' ')
-			if !nullable {
-				sb.WriteString("NOT ")
-			}
-			sb.WriteString("NULL")
 		}
-		colNames[i] = sb.String()
+		sb.WriteRune(d)
 	}
 
-	return
+	sb.WriteRune(' ')
+	if n == 1 {
+		sb.WriteString(singular)
+	} else {
+		sb.WriteString(plural)
+	}
+	return sb.String()
+}
+
+// formatElapsed formats a duration rounded to a readable precision.
+func formatElapsed(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
 }
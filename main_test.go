@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/golang-sql/sqlexp"
+	"golang.org/x/text/encoding/unicode"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeColumn describes a column of a fakeResultSet.
+type fakeColumn struct {
+	name      string
+	typeName  string
+	precision int64
+	scale     int64
+}
+
+// fakeResultSet is one result set returned by the fake driver; with no columns it stands
+// for a statement that only affects rows.
+type fakeResultSet struct {
+	columns []fakeColumn
+	rows    [][]driver.Value
+}
+
+// fakeConnector is a database/sql connector whose connections answer every query with the
+// same result sets, delivered through sqlexp messages like go-mssqldb does.
+type fakeConnector struct {
+	resultSets []fakeResultSet
+	// respond, if set, chooses the result sets for each query instead.
+	respond func(query string) []fakeResultSet
+
+	mu        sync.Mutex
+	queries   []string
+	commits   int
+	rollbacks int
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) { return &fakeConn{c: c}, nil }
+func (c *fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+// executed returns the text of every query executed so far.
+func (c *fakeConnector) executed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.queries...)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use fakeConnector") }
+
+type fakeConn struct {
+	c   *fakeConnector
+	msg *sqlexp.ReturnMessage
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return fakeTx{c: c.c}, nil }
+
+// fakeTx counts the commits and rollbacks of its connector's transactions.
+type fakeTx struct {
+	c *fakeConnector
+}
+
+func (tx fakeTx) Commit() error {
+	tx.c.mu.Lock()
+	defer tx.c.mu.Unlock()
+	tx.c.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.c.mu.Lock()
+	defer tx.c.mu.Unlock()
+	tx.c.rollbacks++
+	return nil
+}
+
+func (c *fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if msg, ok := nv.Value.(*sqlexp.ReturnMessage); ok {
+		sqlexp.ReturnMessageInit(msg)
+		c.msg = msg
+		return driver.ErrRemoveArgument
+	}
+	return driver.ErrSkip
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.c.mu.Lock()
+	c.c.queries = append(c.c.queries, query)
+	c.c.mu.Unlock()
+
+	resultSets := c.c.resultSets
+	if c.c.respond != nil {
+		resultSets = c.c.respond(query)
+	}
+
+	if c.msg != nil {
+		for _, rs := range resultSets {
+			if len(rs.columns) > 0 {
+				sqlexp.ReturnMessageEnqueue(ctx, c.msg, sqlexp.MsgNext{})
+			}
+			sqlexp.ReturnMessageEnqueue(ctx, c.msg, sqlexp.MsgRowsAffected{Count: int64(len(rs.rows))})
+			sqlexp.ReturnMessageEnqueue(ctx, c.msg, sqlexp.MsgNextResultSet{})
+		}
+		c.msg = nil
+	}
+	return &fakeRows{resultSets: resultSets}, nil
+}
+
+type fakeRows struct {
+	resultSets []fakeResultSet
+	set        int
+	row        int
+}
+
+func (r *fakeRows) current() fakeResultSet {
+	if r.set >= len(r.resultSets) {
+		return fakeResultSet{}
+	}
+	return r.resultSets[r.set]
+}
+
+func (r *fakeRows) Columns() []string {
+	var names []string
+	for _, col := range r.current().columns {
+		names = append(names, col.name)
+	}
+	return names
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	return r.current().columns[i].typeName
+}
+
+func (r *fakeRows) ColumnTypePrecisionScale(i int) (int64, int64, bool) {
+	col := r.current().columns[i]
+	return col.precision, col.scale, col.precision > 0
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	rs := r.current()
+	if r.row >= len(rs.rows) {
+		return io.EOF
+	}
+	copy(dest, rs.rows[r.row])
+	r.row++
+	return nil
+}
+
+func (r *fakeRows) HasNextResultSet() bool { return r.set+1 < len(r.resultSets) }
+
+func (r *fakeRows) NextResultSet() error {
+	if !r.HasNextResultSet() {
+		return io.EOF
+	}
+	r.set++
+	r.row = 0
+	return nil
+}
+
+// newFakeQuery returns a queryCSV writing CSV to out and stderr, connected to the fake driver
+// unless connector is nil.
+func newFakeQuery(connector *fakeConnector, out *bytes.Buffer, stderr *bytes.Buffer) *queryCSV {
+	newWriter := func(out io.Writer) resultWriter {
+		return newCSVResultWriter(out, csvOptions{nullString: "NULL", comma: ','})
+	}
+	var c *sql.DB
+	if connector != nil {
+		c = sql.OpenDB(connector)
+	}
+	return &queryCSV{
+		c:            c,
+		rw:           newWriter(out),
+		newWriter:    newWriter,
+		out:          out,
+		stderr:       stderr,
+		planOut:      stderr,
+		queryTimeout: time.Minute,
+		batchSep:     "GO",
+		inputEnc:     unicode.UTF8BOM,
+		maxLine:      64 * 1024,
+	}
+}
+
+// execFakeScript executes script against the fake driver and returns its output and stderr.
+func execFakeScript(t *testing.T, connector *fakeConnector, script string) (string, string) {
+	t.Helper()
+
+	var out, stderr bytes.Buffer
+	q := newFakeQuery(connector, &out, &stderr)
+	defer func() {
+		_ = q.c.Close()
+	}()
+	if err := q.execScript(context.Background(), strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	return out.String(), stderr.String()
+}
+
+// testColumn is a columnType with a fixed schema, standing in for *sql.ColumnType.
+type testColumn struct {
+	name       string
+	typeName   string
+	length     int64
+	hasLength  bool
+	precision  int64
+	scale      int64
+	hasDecimal bool
+	nullable   bool
+}
+
+func (c testColumn) Name() string             { return c.name }
+func (c testColumn) DatabaseTypeName() string { return c.typeName }
+func (c testColumn) Length() (int64, bool)    { return c.length, c.hasLength }
+func (c testColumn) DecimalSize() (int64, int64, bool) {
+	return c.precision, c.scale, c.hasDecimal
+}
+func (c testColumn) Nullable() (bool, bool) { return c.nullable, true }
+
+// writeResultSets writes each result set of rows through rw and returns the output.
+func writeResultSets(t *testing.T, newWriter func(out *bytes.Buffer) resultWriter, colTypes []columnType, resultSets ...[][]any) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	rw := newWriter(&out)
+	for i, rows := range resultSets {
+		if i > 0 {
+			if err := rw.SeparateResultSets(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rw.BeginResultSet(colTypes); err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			if err := rw.WriteRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rw.EndResultSet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func newTestCSVWriter(opts csvOptions) func(out *bytes.Buffer) resultWriter {
+	if opts.comma == 0 {
+		opts.comma = ','
+	}
+	return func(out *bytes.Buffer) resultWriter {
+		return newCSVResultWriter(out, opts)
+	}
+}
+
+func TestUniqueIdentifierCSV(t *testing.T) {
+	// 6F9619FF-8B86-D011-B42D-00C04FC964FF as SQL Server sends it, with the first three groups little-endian:
+	guid := []byte{0xFF, 0x19, 0x96, 0x6F, 0x86, 0x8B, 0x11, 0xD0, 0xB4, 0x2D, 0x00, 0xC0, 0x4F, 0xC9, 0x64, 0xFF}
+
+	colTypes := []columnType{testColumn{name: "id", typeName: "UNIQUEIDENTIFIER", nullable: true}}
+	got := writeResultSets(t, newTestCSVWriter(csvOptions{nullString: "NULL"}), colTypes, [][]any{{guid}, {nil}})
+
+	want := "[id] UNIQUEIDENTIFIER NULL\n6F9619FF-8B86-D011-B42D-00C04FC964FF\nNULL\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMultipleResultSets(t *testing.T) {
+	connector := &fakeConnector{resultSets: []fakeResultSet{
+		{columns: []fakeColumn{{name: "a", typeName: "INT"}}, rows: [][]driver.Value{{int64(1)}}},
+		{},
+		{columns: []fakeColumn{{name: "b", typeName: "VARCHAR"}}, rows: [][]driver.Value{{"x"}, {"y"}}},
+		{columns: []fakeColumn{{name: "c", typeName: "INT"}}, rows: [][]driver.Value{{nil}}},
+	}}
+	out, stderr := execFakeScript(t, connector, "SELECT 1\nGO\n")
+
+	want := "[a] INT\n1\n\n[b] VARCHAR\nx\ny\n\n[c] INT\nNULL\n---\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if want := "-- 0 rows affected\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+}
+
+// TestServerMultipleResultSets runs against a real server named by the SQLQ_TEST_CS connection string.
+func TestServerMultipleResultSets(t *testing.T) {
+	connectionString := os.Getenv("SQLQ_TEST_CS")
+	if connectionString == "" {
+		t.Skip("SQLQ_TEST_CS is not set")
+	}
+
+	var out, stderr bytes.Buffer
+	q := newFakeQuery(nil, &out, &stderr)
+	q.connTimeout = 30 * time.Second
+	if err := q.execServer(context.Background(), connectionString, "sql", "", "SELECT 1 AS a; SELECT 'x' AS b; SELECT CAST(NULL AS INT) AS c;", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[a] INT NOT NULL\n1\n\n[b] VARCHAR(1) NOT NULL\nx\n\n[c] INT NULL\nNULL\n---\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("unexpected stderr %q", stderr.String())
+	}
+}
+
+func TestExecScriptLongLine(t *testing.T) {
+	line := "SELECT '" + strings.Repeat("x", 1024*1024) + "'"
+	script := line + "\nGO\n"
+
+	tests := []struct {
+		maxLine int
+		wantErr bool
+	}{
+		{2 * 1024 * 1024, false},
+		{1024 * 1024, true},
+	}
+	for _, test := range tests {
+		connector := &fakeConnector{resultSets: []fakeResultSet{{}}}
+		var out, stderr bytes.Buffer
+		q := newFakeQuery(connector, &out, &stderr)
+		q.maxLine = test.maxLine
+
+		err := q.execScript(context.Background(), strings.NewReader(script))
+		_ = q.c.Close()
+
+		if test.wantErr {
+			want := fmt.Sprintf("bufio.Scanner: token too long (longer than the -maxline limit of %d bytes)", test.maxLine)
+			if !errors.Is(err, bufio.ErrTooLong) || err.Error() != want {
+				t.Errorf("maxLine %d: got error %v, want %q", test.maxLine, err, want)
+			}
+			if queries := connector.executed(); len(queries) != 0 {
+				t.Errorf("maxLine %d: executed %d queries after the line was rejected", test.maxLine, len(queries))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("maxLine %d: %v", test.maxLine, err)
+		}
+		if queries := connector.executed(); len(queries) != 1 || queries[0] != line+"\r\n" {
+			t.Errorf("maxLine %d: the batch was not executed intact", test.maxLine)
+		}
+	}
+}
+
+func TestExecScriptSeparatorInsideText(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    []string
+		warning bool
+	}{
+		{"string", "SELECT 'a\nGO\nb'\nGO\n", []string{"SELECT 'a\r\nGO\r\nb'\r\n"}, true},
+		{"bracket", "SELECT 1 AS [a\nGO\n]\nGO\n", []string{"SELECT 1 AS [a\r\nGO\r\n]\r\n"}, true},
+		{"block comment", "/*\nGO\n*/\nSELECT 1\nGO\n", []string{"/*\r\nGO\r\n*/\r\nSELECT 1\r\n"}, true},
+		{"directive in comment", "/*\n-- sqlq:timeout=5\n*/\nGO\n", []string{"/*\r\n-- sqlq:timeout=5\r\n*/\r\n"}, false},
+		{"line comment", "SELECT 1 -- don't\nGO\nSELECT 2\nGO\n", []string{"SELECT 1 -- don't\r\n", "SELECT 2\r\n"}, false},
+	}
+	for _, test := range tests {
+		connector := &fakeConnector{resultSets: []fakeResultSet{{}}}
+		_, stderr := execFakeScript(t, connector, test.script)
+		if got := connector.executed(); !slices.Equal(got, test.want) {
+			t.Errorf("%s: executed %q, want %q", test.name, got, test.want)
+		}
+		if got := strings.Contains(stderr, "does not end the batch"); got != test.warning {
+			t.Errorf("%s: got stderr %q, want warning %t", test.name, stderr, test.warning)
+		}
+	}
+}
+
+func TestForJSONResultSet(t *testing.T) {
+	// the result of SELECT id, name FROM t FOR JSON PATH, split into chunks across rows:
+	connector := &fakeConnector{resultSets: []fakeResultSet{
+		{columns: []fakeColumn{{name: "n", typeName: "INT"}}, rows: [][]driver.Value{{int64(1)}}},
+		{
+			columns: []fakeColumn{{name: forJSONColumnName, typeName: "NVARCHAR"}},
+			rows:    [][]driver.Value{{`[{"id":1,"name":"a,`}, {`b"},{"id":2,`}, {`"name":"c\"d"}]`}},
+		},
+		{columns: []fakeColumn{{name: "n", typeName: "INT"}}, rows: [][]driver.Value{{int64(2)}}},
+	}}
+	out, _ := execFakeScript(t, connector, "SELECT id, name FROM t FOR JSON PATH\nGO\n")
+
+	want := "[n] INT\n1\n\n" +
+		`[{"id":1,"name":"a,b"},{"id":2,"name":"c\"d"}]` + "\n" +
+		"\n[n] INT\n2\n---\n"
+	if out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestParallelOutputOrder(t *testing.T) {
+	// later batches finish first:
+	connector := &fakeConnector{respond: func(query string) []fakeResultSet {
+		n, _ := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(query, "SELECT")))
+		time.Sleep(time.Duration(5-n) * 10 * time.Millisecond)
+		return []fakeResultSet{{
+			columns: []fakeColumn{{name: "n", typeName: "INT"}},
+			rows:    [][]driver.Value{{int64(n)}},
+		}}
+	}}
+
+	var out, stderr bytes.Buffer
+	q := newFakeQuery(connector, &out, &stderr)
+	q.parallel = 4
+	q.timing = true
+	q.pool = newBatchPool(q, q.parallel)
+	err := q.execScript(context.Background(), strings.NewReader("SELECT 1\nGO\nSELECT 2\nGO\n-- sqlq:bogus\nSELECT 3\nGO\nSELECT 4\nGO\n"))
+	q.pool.wait()
+	_ = q.c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "[n] INT\n1\n---\n[n] INT\n2\n---\n[n] INT\n3\n---\n[n] INT\n4\n---\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// each batch's timing line follows the previous batch's, with the warning in script order:
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+		lines = append(lines, strings.SplitN(line, " in ", 2)[0])
+	}
+	wantLines := []string{"-- 1 row", "-- 1 row", "-- ignoring unknown sqlq directive 'bogus'", "-- 1 row", "-- 1 row"}
+	if !slices.Equal(lines, wantLines) {
+		t.Errorf("got stderr %q, want %q", lines, wantLines)
+	}
+	if q.stats.batches != 4 || q.stats.rows != 4 {
+		t.Errorf("got stats %+v, want 4 batches and 4 rows", q.stats)
+	}
+}
+
+func TestMaxRowsTransaction(t *testing.T) {
+	intRows := fakeResultSet{
+		columns: []fakeColumn{{name: "n", typeName: "INT"}},
+		rows:    [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}},
+	}
+
+	tests := []struct {
+		maxRows   int
+		wantOK    bool
+		wantErr   string
+		commits   int
+		rollbacks int
+	}{
+		{maxRows: 0, wantOK: true, commits: 1},
+		{maxRows: 3, wantOK: true, commits: 1},
+		{maxRows: 2, wantOK: false, wantErr: "transaction rolled back after result set was truncated (via -maxrows flag)", rollbacks: 1},
+	}
+	for _, test := range tests {
+		connector := &fakeConnector{resultSets: []fakeResultSet{{rows: make([][]driver.Value, 5)}, intRows}}
+
+		var out, stderr bytes.Buffer
+		q := newFakeQuery(connector, &out, &stderr)
+		q.tx = true
+		q.maxRows = test.maxRows
+		ok := q.execBatch(context.Background(), "UPDATE dbo.Widget SET n = n + 1; SELECT n FROM dbo.Widget", q.queryTimeout)
+		_ = q.c.Close()
+
+		if ok != test.wantOK || q.failed == test.wantOK {
+			t.Errorf("maxrows %d: got ok %v, failed %v, want ok %v", test.maxRows, ok, q.failed, test.wantOK)
+		}
+		if test.wantErr != "" && !strings.Contains(stderr.String(), test.wantErr+"\n") {
+			t.Errorf("maxrows %d: got stderr %q, want %q", test.maxRows, stderr.String(), test.wantErr)
+		}
+		if connector.commits != test.commits || connector.rollbacks != test.rollbacks {
+			t.Errorf("maxrows %d: got %d commits and %d rollbacks, want %d and %d", test.maxRows, connector.commits, connector.rollbacks, test.commits, test.rollbacks)
+		}
+	}
+}
+
+func TestTimeoutDirective(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"5", ""},
+		{"0", "-- ignoring invalid sqlq:timeout directive value '0'\n"},
+		{"-1", "-- ignoring invalid sqlq:timeout directive value '-1'\n"},
+		{"soon", "-- ignoring invalid sqlq:timeout directive value 'soon'\n"},
+	}
+	for _, test := range tests {
+		connector := &fakeConnector{resultSets: []fakeResultSet{{
+			columns: []fakeColumn{{name: "n", typeName: "INT"}},
+			rows:    [][]driver.Value{{int64(1)}},
+		}}}
+		out, stderr := execFakeScript(t, connector, "-- sqlq:timeout="+test.value+"\nSELECT 1\nGO\n")
+
+		if want := "[n] INT\n1\n---\n"; out != want {
+			t.Errorf("timeout=%s: got %q, want %q", test.value, out, want)
+		}
+		if stderr != test.wantErr {
+			t.Errorf("timeout=%s: got stderr %q, want %q", test.value, stderr, test.wantErr)
+		}
+	}
+}
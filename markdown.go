@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// markdownResultWriter writes each result set as a GitHub-flavored Markdown table.
+type markdownResultWriter struct {
+	bw          *bufio.Writer
+	nullString  string
+	valueFormat valueFormatter
+
+	colTypes  []columnType
+	formatted []string
+}
+
+func newMarkdownResultWriter(out io.Writer, nullString string, valueFormat valueFormatter) *markdownResultWriter {
+	return &markdownResultWriter{
+		bw:          bufio.NewWriter(out),
+		nullString:  nullString,
+		valueFormat: valueFormat,
+	}
+}
+
+func (w *markdownResultWriter) WriteComment(text string) (err error) {
+	_, err = w.bw.WriteString("<!-- " + strings.ReplaceAll(text, "--", "- -") + " -->\n\n")
+	return
+}
+
+func (w *markdownResultWriter) BeginResultSet(colTypes []columnType) (err error) {
+	w.colTypes = colTypes
+	w.formatted = make([]string, len(colTypes))
+
+	// header row of column names followed by the separator row:
+	for i := range colTypes {
+		w.formatted[i] = colTypes[i].Name()
+	}
+	if err = w.writeLine(w.formatted); err != nil {
+		return
+	}
+	for i := range w.formatted {
+		w.formatted[i] = "---"
+	}
+	return w.writeLine(w.formatted)
+}
+
+func (w *markdownResultWriter) WriteRow(values []any) (err error) {
+	for i, value := range values {
+		if value == nil {
+			w.formatted[i] = w.nullString
+			continue
+		}
+
+		if w.formatted[i], err = w.valueFormat.format(w.colTypes[i], value); err != nil {
+			return fmt.Errorf("error formatting column %d: %w", i+1, err)
+		}
+	}
+
+	return w.writeLine(w.formatted)
+}
+
+// markdownEscaper keeps cell values from breaking out of their table cell.
+var markdownEscaper = strings.NewReplacer(
+	"|", `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+func (w *markdownResultWriter) writeLine(cells []string) (err error) {
+	_ = w.bw.WriteByte('|')
+	for _, cell := range cells {
+		_ = w.bw.WriteByte(' ')
+		_, _ = markdownEscaper.WriteString(w.bw, cell)
+		_, _ = w.bw.WriteString(" |")
+	}
+	return w.bw.WriteByte('\n')
+}
+
+func (w *markdownResultWriter) EndResultSet() error {
+	return nil
+}
+
+func (w *markdownResultWriter) SeparateResultSets() error {
+	return w.bw.WriteByte('\n')
+}
+
+func (w *markdownResultWriter) EndBatch() (err error) {
+	if _, err = w.bw.WriteString("\n---\n"); err != nil {
+		return
+	}
+	return w.Flush()
+}
+
+func (w *markdownResultWriter) Flush() error {
+	return w.bw.Flush()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// batchPool executes batches on up to a fixed number of workers sharing the connection pool.
+// Each batch's output, stderr messages, and plans are buffered and written out whole, in the
+// order the batches were submitted, so concurrent batches never interleave.
+type batchPool struct {
+	q       *queryCSV
+	workers chan struct{}
+	queue   chan *pooledBatch
+	done    chan struct{}
+	failed  atomic.Bool
+
+	// totals merged from finished batches, folded into q by wait:
+	stats     runStats
+	anyFailed bool
+}
+
+// pooledBatch is a submitted batch and its buffered output.
+type pooledBatch struct {
+	q      queryCSV
+	out    bytes.Buffer
+	stderr bytes.Buffer
+	plans  bytes.Buffer
+	done   chan struct{}
+}
+
+func newBatchPool(q *queryCSV, workers int) *batchPool {
+	p := &batchPool{
+		q:       q,
+		workers: make(chan struct{}, workers),
+		queue:   make(chan *pooledBatch, workers),
+		done:    make(chan struct{}),
+	}
+	go p.writeResults()
+	return p
+}
+
+// submit starts the batch once a worker is free. It reports false if any batch has failed so far.
+func (p *batchPool) submit(ctx context.Context, text string, timeout time.Duration) bool {
+	// each batch runs with a private copy of the query settings writing into its own buffers:
+	b := &pooledBatch{
+		q:    *p.q,
+		done: make(chan struct{}),
+	}
+	b.q.rw = p.q.newWriter(&b.out)
+	b.q.out = &b.out
+	b.q.stderr = &b.stderr
+	b.q.pool = nil
+	b.q.failed = false
+	b.q.stats = runStats{}
+	if p.q.planOut == p.q.stderr {
+		// keep plans in order with the batch's other messages:
+		b.q.planOut = &b.stderr
+	} else {
+		b.q.planOut = &b.plans
+	}
+
+	p.workers <- struct{}{}
+	p.queue <- b
+	go func() {
+		defer func() {
+			<-p.workers
+		}()
+
+		if !b.q.execBatch(ctx, text, timeout) {
+			p.failed.Store(true)
+		}
+		close(b.done)
+	}()
+
+	return !p.failed.Load()
+}
+
+// note queues a message for stderr, written after the output of every batch submitted before it.
+func (p *batchPool) note(message string) {
+	b := &pooledBatch{done: make(chan struct{})}
+	b.stderr.WriteString(message)
+	close(b.done)
+
+	p.queue <- b
+}
+
+// writeResults writes each batch's buffered output in submission order as it finishes.
+func (p *batchPool) writeResults() {
+	defer close(p.done)
+
+	for b := range p.queue {
+		<-b.done
+
+		if _, err := p.q.out.Write(b.out.Bytes()); err != nil {
+			_, _ = fmt.Fprintf(p.q.stderr, "error writing batch output: %v\n", err)
+		}
+		_, _ = p.q.stderr.Write(b.stderr.Bytes())
+		if _, err := p.q.planOut.Write(b.plans.Bytes()); err != nil {
+			_, _ = fmt.Fprintf(p.q.stderr, "error writing query plans: %v\n", err)
+		}
+
+		p.stats.batches += b.q.stats.batches
+		p.stats.rows += b.q.stats.rows
+		p.stats.errors += b.q.stats.errors
+		p.anyFailed = p.anyFailed || b.q.failed
+	}
+}
+
+// wait waits for all submitted batches to finish and be written, then adds their totals to q.
+func (p *batchPool) wait() {
+	close(p.queue)
+	<-p.done
+
+	p.q.stats.batches += p.stats.batches
+	p.q.stats.rows += p.stats.rows
+	p.q.stats.errors += p.stats.errors
+	p.q.failed = p.q.failed || p.anyFailed
+}
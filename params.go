@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paramFlags collects repeated -p flags of the form "@name=value" or "@name:type=value"
+// as named query parameters.
+type paramFlags []any
+
+func (p *paramFlags) String() string {
+	return fmt.Sprintf("%d parameters", len(*p))
+}
+
+func (p *paramFlags) Set(s string) (err error) {
+	spec, text, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("expected @name=value or @name:type=value")
+	}
+
+	name, typeName, _ := strings.Cut(spec, ":")
+	name = strings.TrimPrefix(name, "@")
+	if name == "" {
+		return fmt.Errorf("missing parameter name")
+	}
+
+	var value any
+	if value, err = parseParamValue(typeName, text); err != nil {
+		return fmt.Errorf("parameter @%s: %w", name, err)
+	}
+
+	*p = append(*p, sql.Named(name, value))
+	return
+}
+
+// localDateTimeLayout is ISO 8601 without a UTC offset, as accepted by SQL Server for datetime2.
+const localDateTimeLayout = "2006-01-02T15:04:05.999999999"
+
+// parseParamValue converts parameter text to a Go value according to its type hint.
+func parseParamValue(typeName string, text string) (value any, err error) {
+	switch strings.ToLower(typeName) {
+	case "", "nvarchar", "string":
+		value = text
+	case "varchar":
+		value = mssql.VarChar(text)
+	case "bigint":
+		value, err = strconv.ParseInt(text, 10, 64)
+	case "int":
+		var n int64
+		n, err = strconv.ParseInt(text, 10, 32)
+		value = int32(n)
+	case "smallint":
+		var n int64
+		n, err = strconv.ParseInt(text, 10, 16)
+		value = int16(n)
+	case "tinyint":
+		var n uint64
+		n, err = strconv.ParseUint(text, 10, 8)
+		value = byte(n)
+	case "float":
+		value, err = strconv.ParseFloat(text, 64)
+	case "real":
+		var f float64
+		f, err = strconv.ParseFloat(text, 32)
+		value = float32(f)
+	case "bit", "bool":
+		value, err = strconv.ParseBool(text)
+	case "datetime", "datetime2":
+		// an offset is optional and, as when SQL Server converts a datetimeoffset, is dropped
+		// to leave the local time as written:
+		var t time.Time
+		if t, err = time.Parse(time.RFC3339Nano, text); err != nil {
+			t, err = time.Parse(localDateTimeLayout, text)
+		}
+		if strings.EqualFold(typeName, "datetime") {
+			value = mssql.DateTime1(t)
+		} else {
+			value = civil.DateTimeOf(t)
+		}
+	case "datetimeoffset":
+		var t time.Time
+		t, err = time.Parse(time.RFC3339Nano, text)
+		value = mssql.DateTimeOffset(t)
+	case "date":
+		var t time.Time
+		t, err = time.Parse("2006-01-02", text)
+		value = civil.DateOf(t)
+	default:
+		err = fmt.Errorf("unknown parameter type '%s'", typeName)
+	}
+
+	return
+}
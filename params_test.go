@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/golang-sql/civil"
+	mssql "github.com/microsoft/go-mssqldb"
+	"testing"
+	"time"
+)
+
+func TestParseParamValue(t *testing.T) {
+	tests := []struct {
+		typeName string
+		text     string
+		want     any
+		wantErr  bool
+	}{
+		{"", "foo", "foo", false},
+		{"string", "foo", "foo", false},
+		{"varchar", "foo", mssql.VarChar("foo"), false},
+		{"bigint", "9000000000", int64(9000000000), false},
+		{"int", "-42", int32(-42), false},
+		{"int", "9000000000", nil, true},
+		{"smallint", "-42", int16(-42), false},
+		{"tinyint", "255", byte(255), false},
+		{"tinyint", "-1", nil, true},
+		{"float", "0.1", float64(0.1), false},
+		{"real", "0.1", float32(0.1), false},
+		{"bool", "true", true, false},
+		{"date", "2024-03-01", civil.Date{Year: 2024, Month: 3, Day: 1}, false},
+		{"datetime", "2024-03-01T12:34:56", mssql.DateTime1(time.Date(2024, 3, 1, 12, 34, 56, 0, time.UTC)), false},
+		{"datetime", "2024-03-01T12:34:56.5Z", mssql.DateTime1(time.Date(2024, 3, 1, 12, 34, 56, 500000000, time.UTC)), false},
+		{"datetime2", "2024-03-01T12:34:56.1234567", civil.DateTime{Date: civil.Date{Year: 2024, Month: 3, Day: 1}, Time: civil.Time{Hour: 12, Minute: 34, Second: 56, Nanosecond: 123456700}}, false},
+		{"datetime2", "2024-03-01T12:34:56-07:00", civil.DateTime{Date: civil.Date{Year: 2024, Month: 3, Day: 1}, Time: civil.Time{Hour: 12, Minute: 34, Second: 56}}, false},
+		{"datetime", "2024-03-01 12:34:56", nil, true},
+		{"datetimeoffset", "2024-03-01T12:34:56", nil, true},
+		{"bogus", "1", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseParamValue(test.typeName, test.text)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %q: got %#v, want an error", test.typeName, test.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %q: %v", test.typeName, test.text, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s %q: got %#v, want %#v", test.typeName, test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseParamDateTimeOffset(t *testing.T) {
+	got, err := parseParamValue("datetimeoffset", "2024-03-01T12:34:56+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dto, ok := got.(mssql.DateTimeOffset)
+	if !ok {
+		t.Fatalf("got %T, want mssql.DateTimeOffset", got)
+	}
+	if want := time.Date(2024, 3, 1, 10, 34, 56, 0, time.UTC); !time.Time(dto).Equal(want) {
+		t.Errorf("got %v, want %v", time.Time(dto), want)
+	}
+	if _, offset := time.Time(dto).Zone(); offset != 2*60*60 {
+		t.Errorf("got offset %d, want +02:00 kept", offset)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// rawResultWriter writes each value of a single-column result set on its own line,
+// without a header, quoting, or separators.
+type rawResultWriter struct {
+	bw          *bufio.Writer
+	nullString  string
+	valueFormat valueFormatter
+
+	colType columnType
+}
+
+func newRawResultWriter(out io.Writer, nullString string, valueFormat valueFormatter) *rawResultWriter {
+	return &rawResultWriter{
+		bw:          bufio.NewWriter(out),
+		nullString:  nullString,
+		valueFormat: valueFormat,
+	}
+}
+
+func (w *rawResultWriter) WriteComment(text string) error {
+	// raw output carries nothing but values.
+	return nil
+}
+
+func (w *rawResultWriter) BeginResultSet(colTypes []columnType) error {
+	if len(colTypes) != 1 {
+		return fmt.Errorf("-raw output requires a single-column result set but this one has %d columns", len(colTypes))
+	}
+
+	w.colType = colTypes[0]
+	return nil
+}
+
+func (w *rawResultWriter) WriteRow(values []any) (err error) {
+	formatted := w.nullString
+	if values[0] != nil {
+		if formatted, err = w.valueFormat.format(w.colType, values[0]); err != nil {
+			return fmt.Errorf("error formatting column 1: %w", err)
+		}
+	}
+
+	_, _ = w.bw.WriteString(formatted)
+	return w.bw.WriteByte('\n')
+}
+
+func (w *rawResultWriter) EndResultSet() error {
+	return nil
+}
+
+func (w *rawResultWriter) SeparateResultSets() error {
+	return nil
+}
+
+func (w *rawResultWriter) EndBatch() error {
+	return w.Flush()
+}
+
+func (w *rawResultWriter) Flush() error {
+	return w.bw.Flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mssql "github.com/microsoft/go-mssqldb"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+// retryPolicy retries operations failing with transient errors using exponential backoff.
+type retryPolicy struct {
+	retries int
+	delay   time.Duration
+}
+
+// do calls fn, retrying up to p.retries more times while it fails with a transient error.
+// Each retry is logged to w.
+func (p retryPolicy) do(ctx context.Context, w io.Writer, what string, fn func() error) (err error) {
+	delay := p.delay
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil || attempt >= p.retries || !isTransientError(err) {
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "-- %s failed (attempt %d of %d); retrying in %s: %v\n", what, attempt+1, p.retries+1, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// transientErrorNumbers are SQL Server error numbers indicating a condition worth retrying,
+// chiefly Azure SQL throttling and failover.
+var transientErrorNumbers = map[int32]bool{
+	233:   true, // connection closed by server
+	4060:  true, // cannot open database
+	4221:  true, // login to read-secondary failed during reconfiguration
+	10053: true, // transport-level error
+	10054: true, // connection forcibly closed
+	10060: true, // network timeout
+	10928: true, // resource limit reached
+	10929: true, // resource limit reached
+	40143: true, // connection could not be initialized
+	40197: true, // service error processing request
+	40501: true, // service is busy
+	40540: true, // service encountered an error
+	40613: true, // database not currently available
+	49918: true, // not enough resources to process request
+	49919: true, // too many create/update operations in progress
+	49920: true, // too many operations in progress
+}
+
+// isTransientError reports whether err is a network blip or SQL Server error worth retrying.
+func isTransientError(err error) bool {
+	var sqlErr mssql.Error
+	if errors.As(err, &sqlErr) {
+		return transientErrorNumbers[sqlErr.Number]
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// a DNS lookup that failed outright (no such host) will fail again:
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	mssql "github.com/microsoft/go-mssqldb"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"service busy", mssql.Error{Number: 40501}, true},
+		{"syntax error", mssql.Error{Number: 102}, false},
+		{"canceled", context.Canceled, false},
+		{"connection reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true},
+		{"unexpected EOF", fmt.Errorf("login: %w", io.ErrUnexpectedEOF), true},
+		{"dial timeout", &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"no such host", &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "nosuchhost", IsNotFound: true}}, false},
+		{"temporary DNS failure", &net.DNSError{Err: "server misbehaving", Name: "db", IsTemporary: true}, true},
+		{"DNS timeout", &net.DNSError{Err: "i/o timeout", Name: "db", IsTimeout: true}, true},
+		{"other", errors.New("login failed"), false},
+	}
+	for _, test := range tests {
+		if got := isTransientError(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	loginErr := errors.New("login failed")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+		wantLog   string
+	}{
+		{
+			name:      "transient then success",
+			errs:      []error{io.EOF, nil},
+			wantCalls: 2,
+			wantLog:   "-- connect failed (attempt 1 of 2); retrying in 1ms: EOF\n",
+		},
+		{
+			name:      "not transient",
+			errs:      []error{loginErr, nil},
+			wantErr:   loginErr,
+			wantCalls: 1,
+		},
+		{
+			name:      "out of retries",
+			errs:      []error{io.EOF, io.ErrUnexpectedEOF, nil},
+			wantErr:   io.ErrUnexpectedEOF,
+			wantCalls: 2,
+			wantLog:   "-- connect failed (attempt 1 of 2); retrying in 1ms: EOF\n",
+		},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		calls := 0
+		err := retryPolicy{retries: 1, delay: time.Millisecond}.do(context.Background(), &stderr, "connect", func() error {
+			calls++
+			return test.errs[calls-1]
+		})
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.wantErr)
+		}
+		if calls != test.wantCalls {
+			t.Errorf("%s: got %d calls, want %d", test.name, calls, test.wantCalls)
+		}
+		if got := stderr.String(); got != test.wantLog {
+			t.Errorf("%s: got stderr %q, want %q", test.name, got, test.wantLog)
+		}
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var stderr bytes.Buffer
+	calls := 0
+	tStart := time.Now()
+	err := retryPolicy{retries: 3, delay: time.Hour}.do(ctx, &stderr, "query", func() error {
+		calls++
+		cancel()
+		return io.EOF
+	})
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v, want %v", err, io.EOF)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+	if elapsed := time.Since(tStart); elapsed > time.Minute {
+		t.Errorf("backoff took %s after cancel", elapsed)
+	}
+	if want := "-- query failed (attempt 1 of 4); retrying in 1h0m0s: EOF\n"; stderr.String() != want {
+		t.Errorf("got stderr %q, want %q", stderr.String(), want)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+// columnType describes a result set column; it is satisfied by *sql.ColumnType.
+type columnType interface {
+	Name() string
+	DatabaseTypeName() string
+	Length() (length int64, ok bool)
+	DecimalSize() (precision int64, scale int64, ok bool)
+	Nullable() (nullable bool, ok bool)
+}
+
+// serverColumn is a synthetic leading column naming the server each row came from.
+type serverColumn struct {
+	name string
+}
+
+func (c serverColumn) Name() string                      { return c.name }
+func (c serverColumn) DatabaseTypeName() string          { return "NVARCHAR" }
+func (c serverColumn) Length() (int64, bool)             { return 128, true }
+func (c serverColumn) DecimalSize() (int64, int64, bool) { return 0, 0, false }
+func (c serverColumn) Nullable() (bool, bool)            { return false, true }
+
+// resultWriter renders the result sets of executed batches to an output stream.
+type resultWriter interface {
+	// WriteComment writes an informational line, such as the server a block of output came from,
+	// if the format has a way to express one.
+	WriteComment(text string) error
+	// BeginResultSet starts a new result set with the given column schema.
+	BeginResultSet(colTypes []columnType) error
+	// WriteRow writes one row of scanned values; a nil value is SQL NULL.
+	WriteRow(values []any) error
+	// EndResultSet finishes the current result set.
+	EndResultSet() error
+	// SeparateResultSets is called between consecutive result sets of a batch.
+	SeparateResultSets() error
+	// EndBatch is called after each GO batch has executed.
+	EndBatch() error
+	// Flush writes any buffered output.
+	Flush() error
+}